@@ -0,0 +1,174 @@
+package bitcask
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Error message when a merge window does not fit within a day.
+const InvalidMergeWindow = "merge window must start and end within a day"
+
+// MergeWindow is the daily period of local time in which automatic merging may start.
+// Start and End are durations since midnight, a window ending before it starts spans midnight.
+// The zero MergeWindow is always open.
+type MergeWindow struct {
+    Start time.Duration
+    End time.Duration
+}
+
+// mergeSchedule holds the settings of merging and the background merge.
+type mergeSchedule struct {
+    // running is held for the whole of a merge, so merges run one at a time and Close waits for them.
+    running sync.Mutex
+
+    mu sync.Mutex
+    window MergeWindow
+    bytesPerSecond int64
+    stop chan struct{}
+    done chan struct{}
+}
+
+// rateLimiter spreads the bytes read and written by a merge over time.
+type rateLimiter struct {
+    bytesPerSecond int64
+    start time.Time
+    bytes int64
+}
+
+// Contains reports whether t falls in the window.
+func (w MergeWindow) Contains(t time.Time) bool {
+    if w.Start == w.End {
+        return true
+    }
+
+    year, month, day := t.Date()
+    sinceMidnight := t.Sub(time.Date(year, month, day, 0, 0, 0, 0, t.Location()))
+    if w.Start < w.End {
+        return sinceMidnight >= w.Start && sinceMidnight < w.End
+    }
+    return sinceMidnight >= w.Start || sinceMidnight < w.End
+}
+
+// SetMergeWindow restricts the merges started by SetMergeInterval to the given daily window.
+// Merges called directly are not restricted, a merge started in the window runs to its end.
+// returns an error if the window does not fit within a day.
+func (b *Bitcask) SetMergeWindow(window MergeWindow) error {
+    day := 24 * time.Hour
+    if window.Start < 0 || window.Start >= day || window.End < 0 || window.End >= day {
+        return BitcaskError(InvalidMergeWindow)
+    }
+
+    b.merges.mu.Lock()
+    defer b.merges.mu.Unlock()
+
+    b.merges.window = window
+    return nil
+}
+
+// SetMergeRate limits the bytes every merge reads and writes per second, so it leaves disk bandwidth to Get and Put.
+// A rate of 0 removes the limit, which is the default.
+func (b *Bitcask) SetMergeRate(bytesPerSecond int64) {
+    b.merges.mu.Lock()
+    defer b.merges.mu.Unlock()
+
+    b.merges.bytesPerSecond = bytesPerSecond
+}
+
+// SetMergeInterval runs MergeFragmented in the background every interval while the merge window is open.
+// An interval of 0 stops the background merge, which is the default.
+// returns an error if ReadWrite permission is not set.
+func (b *Bitcask) SetMergeInterval(interval time.Duration) error {
+    if b.config.writePermission == ReadOnly {
+        return BitcaskError(WriteDenied)
+    }
+
+    b.stopMergeInterval()
+    if interval <= 0 {
+        return nil
+    }
+
+    stop, done := make(chan struct{}), make(chan struct{})
+    b.merges.mu.Lock()
+    b.merges.stop, b.merges.done = stop, done
+    b.merges.mu.Unlock()
+
+    go func() {
+        defer close(done)
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        // Stopping cancels a running merge, its files are removed and the datastore is left as it was.
+        ctx, cancel := context.WithCancel(context.Background())
+        defer cancel()
+        go func() {
+            <-stop
+            cancel()
+        }()
+
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case now := <-ticker.C:
+                b.merges.mu.Lock()
+                window := b.merges.window
+                b.merges.mu.Unlock()
+                // A failed merge is tried again at the next tick.
+                if window.Contains(now) {
+                    b.mergeFragmented(ctx)
+                }
+            }
+        }
+    }()
+
+    return nil
+}
+
+// stopMergeInterval stops the background merge and waits for it to end.
+// It must not be called with the bitcask lock held since the background merge takes it.
+func (b *Bitcask) stopMergeInterval() {
+    b.merges.mu.Lock()
+    stop, done := b.merges.stop, b.merges.done
+    b.merges.stop, b.merges.done = nil, nil
+    b.merges.mu.Unlock()
+
+    if stop != nil {
+        close(stop)
+        <-done
+    }
+}
+
+// newLimiter returns the rate limiter of a merge starting now, nil when merges are not limited.
+func (s *mergeSchedule) newLimiter() *rateLimiter {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.bytesPerSecond <= 0 {
+        return nil
+    }
+    return &rateLimiter{bytesPerSecond: s.bytesPerSecond, start: time.Now()}
+}
+
+// wait accounts for n bytes read or written and sleeps until they fit within the rate.
+// returns the error of ctx once it is done, also when the merge is not limited.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+    if l == nil {
+        return ctx.Err()
+    }
+
+    l.bytes += int64(n)
+    due := l.start.Add(time.Duration(float64(l.bytes) / float64(l.bytesPerSecond) * float64(time.Second)))
+    d := time.Until(due)
+    if d <= 0 {
+        return ctx.Err()
+    }
+
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+    select {
+    case <-ctx.Done():
+        return ctx.Err()
+    case <-timer.C:
+        return nil
+    }
+}
@@ -0,0 +1,122 @@
+package bitcask
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMergeWindow(t *testing.T) {
+    t.Run("window contains times of day", func(t *testing.T) {
+        at := func(hour int, minute int) time.Time {
+            return time.Date(2024, 3, 1, hour, minute, 0, 0, time.Local)
+        }
+        tests := []struct {
+            window MergeWindow
+            at time.Time
+            want bool
+        }{
+            {MergeWindow{1 * time.Hour, 5 * time.Hour}, at(0, 30), false},
+            {MergeWindow{1 * time.Hour, 5 * time.Hour}, at(1, 0), true},
+            {MergeWindow{1 * time.Hour, 5 * time.Hour}, at(4, 59), true},
+            {MergeWindow{1 * time.Hour, 5 * time.Hour}, at(5, 0), false},
+            {MergeWindow{22 * time.Hour, 2 * time.Hour}, at(23, 0), true},
+            {MergeWindow{22 * time.Hour, 2 * time.Hour}, at(1, 0), true},
+            {MergeWindow{22 * time.Hour, 2 * time.Hour}, at(12, 0), false},
+            {MergeWindow{}, at(12, 0), true},
+        }
+        for _, test := range tests {
+            if got := test.window.Contains(test.at); got != test.want {
+                t.Errorf("%v contains %v: got %v, want %v", test.window, test.at.Format("15:04"), got, test.want)
+            }
+        }
+    })
+
+    t.Run("window must fit within a day", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        assertError(t, b.SetMergeWindow(MergeWindow{Start: time.Hour, End: 25 * time.Hour}), InvalidMergeWindow)
+        assertError(t, b.SetMergeWindow(MergeWindow{Start: -time.Hour}), InvalidMergeWindow)
+        b.Close()
+
+        r, _ := Open(testBitcaskPath)
+        assertError(t, r.SetMergeInterval(time.Second), WriteDenied)
+        r.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("background merge waits for the window", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        fragmented := b.activeFile.fileName
+        fillActiveFile(b)
+        fillActiveFile(b)
+
+        now := time.Now()
+        sinceMidnight := now.Sub(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()))
+        closed := MergeWindow{
+            Start: (sinceMidnight + 2 * time.Hour) % (24 * time.Hour),
+            End:   (sinceMidnight + 3 * time.Hour) % (24 * time.Hour),
+        }
+        b.SetMergeWindow(closed)
+        b.SetMergeInterval(10 * time.Millisecond)
+        time.Sleep(100 * time.Millisecond)
+        if dataFiles, _ := listDataFiles(testBitcaskPath); !dataFiles[fragmented] {
+            t.Fatalf("expected no merge outside the window")
+        }
+
+        b.SetMergeWindow(MergeWindow{})
+        deadline := time.Now().Add(2 * time.Second)
+        for dataFiles, _ := listDataFiles(testBitcaskPath); dataFiles[fragmented]; dataFiles, _ = listDataFiles(testBitcaskPath) {
+            if time.Now().After(deadline) {
+                t.Fatalf("expected fragmented file %s to be merged once the window opened", fragmented)
+            }
+            time.Sleep(10 * time.Millisecond)
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
+
+func TestMergeRate(t *testing.T) {
+    t.Run("limited merge leaves puts and gets running", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "old")
+        fillActiveFile(b)
+        b.Put("other", "value")
+        fillActiveFile(b)
+
+        // About 20KB are read and written at 50KB per second.
+        b.SetMergeRate(50 * 1024)
+        start := time.Now()
+        merged := make(chan error)
+        go func() {
+            merged <- b.Merge()
+        }()
+
+        time.Sleep(50 * time.Millisecond)
+        opStart := time.Now()
+        b.Put("key1", "new")
+        got, _ := b.Get("other")
+        assertString(t, got, "value")
+        if elapsed := time.Since(opStart); elapsed > 100 * time.Millisecond {
+            t.Errorf("put and get took %v during the merge", elapsed)
+        }
+
+        if err := <-merged; err != nil {
+            t.Fatal(err)
+        }
+        if elapsed := time.Since(start); elapsed < 200 * time.Millisecond {
+            t.Errorf("merge took %v, want the rate limit to slow it down", elapsed)
+        }
+        got, _ = b.Get("key1")
+        assertString(t, got, "new")
+        b.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        got, _ = b.Get("key1")
+        assertString(t, got, "new")
+        got, _ = b.Get("other")
+        assertString(t, got, "value")
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
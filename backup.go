@@ -0,0 +1,191 @@
+package bitcask
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+    // Error message when the backup destination already has files in it.
+    BackupDirNotEmpty = "backup destination is not empty"
+    // Error message when a directory is not a complete bitcask backup.
+    NotABackup = "not a bitcask backup"
+)
+
+// Name of the file listing the files of a backup and their end offsets.
+const backupManifest = "backupmanifest"
+
+// Backup writes a consistent copy of the bitcask datastore into destDir while the datastore stays open.
+// The active file is synced and sealed, then the format file and all data and hint files are hard linked,
+// or copied when linking is not possible, and their end offsets are recorded in a manifest.
+// The newest data file is always copied, a datastore opened on the backup appends to it.
+// Only sealing takes the lock, merges wait for the files to be linked or copied so none of them is removed meanwhile.
+// destDir is on the FileSystem of the datastore, files are only linked on OSFileSystem.
+// The backup can be opened as a regular bitcask datastore or copied back with Restore.
+// returns an error if ReadWrite permission is not set.
+func (b *Bitcask) Backup(destDir string) error {
+    if b.config.writePermission == ReadOnly {
+        return BitcaskError(WriteDenied)
+    }
+
+    b.merges.running.Lock()
+    defer b.merges.running.Unlock()
+
+    files, err := b.sealForBackup(destDir)
+    if err != nil {
+        return err
+    }
+
+    var lastFile string
+    for _, file := range files {
+        if isDataFile(file.Name()) && (lastFile == "" || compareFileIds(file.Name(), lastFile) > 0) {
+            lastFile = file.Name()
+        }
+    }
+
+    var manifest strings.Builder
+    for _, file := range files {
+        name := file.Name()
+        srcPath := path.Join(b.datastorePath, name)
+        destPath := path.Join(destDir, name)
+        if b.fs != OSFileSystem || name == lastFile || os.Link(srcPath, destPath) != nil {
+            if err := copyFile(b.fs, srcPath, destPath, file.Size()); err != nil {
+                return err
+            }
+        }
+
+        fmt.Fprintf(&manifest, "%s %d\n", name, file.Size())
+    }
+
+    return writeSyncedFile(b.fs, path.Join(destDir, backupManifest), manifest.String())
+}
+
+// sealForBackup syncs and seals the active file under the lock and returns the files a backup copies.
+// Sealed files are not written again, so they can be copied once the lock is released.
+func (b *Bitcask) sealForBackup(destDir string) ([]os.FileInfo, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if err := checkEmptyDir(b.fs, destDir); err != nil {
+        return nil, err
+    }
+
+    if err := b.sync(); err != nil {
+        return nil, err
+    }
+    if err := b.createActiveFile(); err != nil {
+        return nil, err
+    }
+
+    if err := b.fs.MkdirAll(destDir); err != nil {
+        return nil, err
+    }
+
+    files, err := b.fs.ReadDir(b.datastorePath)
+    if err != nil {
+        return nil, err
+    }
+
+    var sealed []os.FileInfo
+    for _, file := range files {
+        name := file.Name()
+        if name != b.activeFile.fileName && (isDataFile(name) || isHintFile(name) || name == formatFile) {
+            sealed = append(sealed, file)
+        }
+    }
+    return sealed, nil
+}
+
+// Restore copies a backup made by Backup into dirPath, which must not exist or be empty.
+// Every file is checked against the end offset recorded in the backup manifest.
+func Restore(backupDir string, dirPath string) error {
+    return restore(OSFileSystem, backupDir, dirPath)
+}
+
+// restore copies a backup in backupDir of fsys into dirPath of fsys like Restore.
+func restore(fsys FileSystem, backupDir string, dirPath string) error {
+    manifestData, err := readFile(fsys, path.Join(backupDir, backupManifest))
+    if err != nil {
+        return BitcaskError(fmt.Sprintf("%s: %s", backupDir, NotABackup))
+    }
+
+    if err := checkEmptyDir(fsys, dirPath); err != nil {
+        return err
+    }
+    if err := fsys.MkdirAll(dirPath); err != nil {
+        return err
+    }
+
+    manifestScanner := bufio.NewScanner(strings.NewReader(string(manifestData)))
+    for manifestScanner.Scan() {
+        name, sizeStr, found := strings.Cut(manifestScanner.Text(), " ")
+        size, sizeErr := strconv.ParseInt(sizeStr, 10, 64)
+        if !found || sizeErr != nil {
+            return BitcaskError(fmt.Sprintf("%s: %s", backupDir, NotABackup))
+        }
+
+        info, err := statFile(fsys, path.Join(backupDir, name))
+        if err != nil || info.Size() < size {
+            return BitcaskError(fmt.Sprintf("%s: %s: %s", backupDir, name, NotABackup))
+        }
+
+        if err := copyFile(fsys, path.Join(backupDir, name), path.Join(dirPath, name), size); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// checkEmptyDir returns an error if dirPath exists on fsys and has files in it.
+func checkEmptyDir(fsys FileSystem, dirPath string) error {
+    files, err := fsys.ReadDir(dirPath)
+    if err != nil && !os.IsNotExist(err) {
+        return BitcaskError(fmt.Sprintf("%s: %s", dirPath, CannotOpenThisDir))
+    }
+    if len(files) > 0 {
+        return BitcaskError(fmt.Sprintf("%s: %s", dirPath, BackupDirNotEmpty))
+    }
+    return nil
+}
+
+// copyFile copies the first size bytes of srcPath into a new synced file at destPath, both on fsys.
+func copyFile(fsys FileSystem, srcPath string, destPath string, size int64) error {
+    src, err := fsys.Open(srcPath)
+    if err != nil {
+        return err
+    }
+    defer src.Close()
+
+    dest, err := fsys.OpenFile(destPath, os.O_CREATE | os.O_EXCL | os.O_WRONLY)
+    if err != nil {
+        return err
+    }
+    defer dest.Close()
+
+    if _, err := io.CopyN(dest, src, size); err != nil {
+        return err
+    }
+
+    return dest.Sync()
+}
+
+// writeSyncedFile creates a file on fsys with the given content and syncs it to disk.
+func writeSyncedFile(fsys FileSystem, filePath string, content string) error {
+    file, err := fsys.OpenFile(filePath, os.O_CREATE | os.O_TRUNC | os.O_WRONLY)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    if _, err := io.WriteString(file, content); err != nil {
+        return err
+    }
+
+    return file.Sync()
+}
@@ -0,0 +1,154 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"testing"
+)
+
+var testBackupPath = path.Join("testing_backup_dir")
+
+// blockingFS holds the creation of files in dir until release is closed, blocked is closed once one is held.
+type blockingFS struct {
+    FileSystem
+    dir string
+    once sync.Once
+    blocked chan struct{}
+    release chan struct{}
+}
+
+func (f *blockingFS) OpenFile(name string, flag int) (File, error) {
+    if path.Dir(name) == f.dir {
+        f.once.Do(func() {
+            close(f.blocked)
+        })
+        <-f.release
+    }
+    return f.FileSystem.OpenFile(name, flag)
+}
+
+func TestBackup(t *testing.T) {
+    t.Run("restore backup of a live datastore", func(t *testing.T) {
+        b1, _ := Open(testBitcaskPath, ReadWrite)
+        for i := 0; i < 1000; i++ {
+            b1.Put(fmt.Sprintf("key%d", i + 1), fmt.Sprintf("value%d", i + 1))
+        }
+        b1.Merge()
+        b1.Put("key1", "changed before backup")
+
+        err := b1.Backup(testBackupPath)
+        if err != nil {
+            t.Fatal(err)
+        }
+        b1.Put("key1", "changed after backup")
+        b1.Put("new key", "new value")
+        b1.Merge()
+        b1.Close()
+
+        err = Restore(testBackupPath, testImportPath)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        b2, _ := Open(testImportPath)
+        got, _ := b2.Get("key1")
+        assertString(t, got, "changed before backup")
+        got, _ = b2.Get("key500")
+        assertString(t, got, "value500")
+        _, err = b2.Get("new key")
+        assertError(t, err, "new key: key does not exist")
+        b2.Close()
+
+        os.RemoveAll(testBitcaskPath)
+        os.RemoveAll(testBackupPath)
+        os.RemoveAll(testImportPath)
+    })
+
+    t.Run("open backup as a datastore", func(t *testing.T) {
+        b1, _ := Open(testBitcaskPath, ReadWrite)
+        b1.Put("key1", "value1")
+        b1.Backup(testBackupPath)
+        b1.Close()
+
+        b2, _ := Open(testBackupPath, ReadWrite)
+        got, _ := b2.Get("key1")
+        b2.Put("key2", "written to the backup")
+        b2.Close()
+        assertString(t, got, "value1")
+
+        // The backup appends to a copy of the newest data file, not to a link to the one of the datastore.
+        assertNoPlainText(t, testBitcaskPath, "written to the backup")
+
+        os.RemoveAll(testBitcaskPath)
+        os.RemoveAll(testBackupPath)
+    })
+
+    t.Run("writes go on while the files are copied", func(t *testing.T) {
+        fsys := &blockingFS{
+            FileSystem: NewMemFileSystem(),
+            dir:        testBackupPath,
+            blocked:    make(chan struct{}),
+            release:    make(chan struct{}),
+        }
+        b, _ := OpenFS(fsys, testBitcaskPath, nil, ReadWrite)
+        b.Put("key1", "value1")
+
+        done := make(chan error)
+        go func() {
+            done <- b.Backup(testBackupPath)
+        }()
+        <-fsys.blocked
+
+        if err := b.Put("key2", "value2"); err != nil {
+            t.Fatal(err)
+        }
+        got, _ := b.Get("key1")
+        assertString(t, got, "value1")
+
+        close(fsys.release)
+        if err := <-done; err != nil {
+            t.Fatal(err)
+        }
+        b.Close()
+
+        backup, _ := OpenFS(fsys, testBackupPath, nil)
+        got, _ = backup.Get("key1")
+        assertString(t, got, "value1")
+        _, err := backup.Get("key2")
+        assertError(t, err, "key2: " + KeyDoesNotExist)
+        backup.Close()
+    })
+
+    t.Run("backup into not empty directory", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        err := b.Backup(testBitcaskPath)
+        b.Close()
+
+        assertError(t, err, "testing_dir: backup destination is not empty")
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("restore from directory that is not a backup", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Close()
+
+        err := Restore(testBitcaskPath, testImportPath)
+
+        assertError(t, err, "testing_dir: not a bitcask backup")
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("backup with no write permission", func(t *testing.T) {
+        b1, _ := Open(testBitcaskPath, ReadWrite)
+        b1.Close()
+
+        b2, _ := Open(testBitcaskPath)
+        err := b2.Backup(testBackupPath)
+        b2.Close()
+
+        assertError(t, err, "write permission denied")
+        os.RemoveAll(testBitcaskPath)
+    })
+}
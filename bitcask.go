@@ -1,17 +1,20 @@
 package bitcask
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 )
 
 // Maximum file size 10KB.
 const maxFileSize = 10 * 1024
 
+// Size of the buffered records that makes the next write flush them to the active file 4KB.
+const writeBufferSize = 4 * 1024
+
 const (
     // ReadOnly constant give the bitcask process read only permission.
     ReadOnly     ConfigOpt = 0
@@ -21,6 +24,8 @@ const (
     SyncOnPut    ConfigOpt = 2
     // SyncOnDemand makes the bitcask sync when user calls the sync function.
     SyncOnDemand ConfigOpt = 3
+    // CompressValues makes the bitcask compress values with FlateCodec.
+    CompressValues ConfigOpt = 4
 
     // Error message when key not found when deleting or getting it.
     KeyDoesNotExist = "key does not exist"
@@ -32,6 +37,10 @@ const (
     CannotCreateBitcask = "read only cannot create new bitcask datastore"
     // Error message when a process try to access a bitcask with writer process holding it.
     WriterExist = "another writer exists in this bitcask"
+    // Error message when a record checksum or framing does not match its content.
+    CorruptedRecord = "corrupted record"
+    // Error message when a record ends before its declared size.
+    TruncatedRecord = "truncated record"
 )
 
 const (
@@ -46,7 +55,7 @@ const (
     hintFilePrefix = "hintfile"
 
     // Number and size of fields of file line with constant size.
-    staticFields = 3
+    staticFields = 6
     numberFieldSize = 19
 
     // Constant to determine the process in the bitcask is a reader.
@@ -61,8 +70,13 @@ const (
     // Prefix to read and write process lock.
     writeLock = ".writelock"
 
-    // Value to distinguish the deleted values.
-    tompStone = "DELETE THIS VALUE"
+    // Value size written in hint files for tombstones.
+    hintTombstoneSize = -1
+
+    // Flag of records rewritten by merge, they repeat an older write.
+    mergedFlag = 1
+    // Flag of tombstones, records deleting their key.
+    tombstoneFlag = 4
 )
 
 // ConfigOpt is a type to the config option constants the user pass to open.
@@ -76,21 +90,39 @@ type BitcaskError string
 
 // Bitcask contains the data needed to manipulate the bitcask datastore.
 // user creates an object of it to use the bitcask.
+// It is safe to use from multiple goroutines.
 type Bitcask struct {
+    mu sync.RWMutex
+    fs FileSystem
     datastorePath string
     lock string
     keyDirFile string
     keyDir map[string]record
+    // Last tombstone replayed for each deleted key, kept while the keydir is built and for the life of a follower.
+    tombstones map[string]record
+    // Id of the next data file and sequence number of the last record written.
+    nextFileId int
+    seq int
+    watchers map[*watcher]struct{}
+    mapped mappedFiles
+    cache valueCache
+    commit groupCommit
+    merges mergeSchedule
+    snapshots snapshotPins
     config options
     activeFile datastoreFile
 }
 
 // datastoreFile represents the current active file that is used to append values.
+// Records are buffered before they are written, currentPos and currentSize include the buffered records.
 type datastoreFile struct {
-    file *os.File
+    file File
     fileName string
     currentPos int
     currentSize int
+    // Size of the file when it was resumed on open, the records before it are not written again.
+    resumedSize int
+    buf []byte
 }
 
 // record represents the value of keydir map.
@@ -98,13 +130,35 @@ type record struct {
     fileId string
     valueSize int
     valuePos int
+    // Size of the key as it is stored in the data file.
+    keySize int
+    // Sequence number ordering the writes of the datastore, the wall clock time is kept as metadata only.
+    seq int
     tstamp int
 }
 
+// dataRecord holds the fields of a data file line.
+type dataRecord struct {
+    key string
+    value string
+    seq int
+    tstamp int
+    flags int
+    keySize int
+    valueSize int
+}
+
 // options groups the config options passed to Open.
 type options struct {
     writePermission ConfigOpt
     syncOption ConfigOpt
+    codec Codec
+    compressMinSize int
+    keyring *Keyring
+    encryptKeys bool
+    maxValueSize int
+    mmap bool
+    mergePolicy MergePolicy
 }
 
 // Implement error interface.
@@ -113,17 +167,30 @@ func (e BitcaskError) Error() string {
 }
 
 // Open creates a new process to manipulate the given bitcask datastore path.
-// It takes options ReadWrite, ReadOnly, SyncOnPut and SyncOnDemand.
+// It takes options ReadWrite, ReadOnly, SyncOnPut, GroupCommit and SyncOnDemand.
 // Only one ReadWrite process can open a bitcask at a time.
 // Only ReadWrite permission can create a new bitcask datastore.
 // If there is no bitcask datastore in the given path a new datastore is created when ReadWrite permission is given.
 func Open(dirPath string, opts ...ConfigOpt) (*Bitcask, error) {
+    return openDatastore(context.Background(), OSFileSystem, dirPath, nil, opts, nil)
+}
+
+// OpenFS opens the bitcask datastore in dirPath of fsys like Open, all its files are read and written through fsys.
+// keyring encrypts the datastore like OpenEncrypted, nil when it is not encrypted.
+func OpenFS(fsys FileSystem, dirPath string, keyring *Keyring, opts ...ConfigOpt) (*Bitcask, error) {
+    return openDatastore(context.Background(), fsys, dirPath, keyring, opts, nil)
+}
+
+// openDatastore opens a bitcask datastore on fsys encrypted with keyring, nil when it is not encrypted.
+// Loading the keydir stops when ctx is done, progress is called after every file loaded when not nil.
+func openDatastore(ctx context.Context, fsys FileSystem, dirPath string, keyring *Keyring, opts []ConfigOpt, progress func(Progress)) (*Bitcask, error) {
     var openErr error
 
     bitcask := Bitcask{
+        fs: fsys,
         keyDir: make(map[string]record),
         datastorePath: dirPath,
-        config: options{writePermission: ReadOnly, syncOption: SyncOnDemand},
+        config: options{writePermission: ReadOnly, syncOption: SyncOnDemand, keyring: keyring, mergePolicy: DefaultMergePolicy},
     }
 
     for _, opt := range opts {
@@ -132,14 +199,22 @@ func Open(dirPath string, opts ...ConfigOpt) (*Bitcask, error) {
             bitcask.config.writePermission = ReadWrite
         case SyncOnPut:
             bitcask.config.syncOption = SyncOnPut
+        case GroupCommit:
+            bitcask.config.syncOption = GroupCommit
+        case CompressValues:
+            bitcask.config.codec = FlateCodec
+            bitcask.config.compressMinSize = defaultCompressMinSize
+        case EncryptKeys:
+            bitcask.config.encryptKeys = true
+        case MmapFiles:
+            bitcask.config.mmap = true
         }
     }
 
-    bitcaskDir, pathErr := os.Open(dirPath)
-    defer bitcaskDir.Close()
+    _, pathErr := fsys.ReadDir(dirPath)
 
     if pathErr == nil {
-        openErr = bitcask.openExistingDatastore()
+        openErr = bitcask.openExistingDatastore(ctx, progress)
     } else if os.IsNotExist(pathErr) {
         openErr = bitcask.createNewDatastore()
     } else {
@@ -154,71 +229,201 @@ func Open(dirPath string, opts ...ConfigOpt) (*Bitcask, error) {
 }
 
 // Get retrieves the value by key from a bitcask datastore.
+// Values are served from the value cache when SetCacheSize turned it on.
 // returns an error if key does not exist in the bitcask datastore.
 func (b *Bitcask) Get(key string) (string, error) {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    if value, isExist := b.cache.get(key); isExist {
+        return value, nil
+    }
+
+    value, err := b.get(key)
+    if err == nil {
+        b.cache.add(key, value)
+    }
+    return value, err
+}
+
+// Has reports whether key exists in a bitcask datastore without reading its value.
+func (b *Bitcask) Has(key string) bool {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    _, isExist := b.keyDir[key]
+    return isExist
+}
+
+// get reads the value of key, the caller holds the lock.
+func (b *Bitcask) get(key string) (string, error) {
     rec, isExist := b.keyDir[key]
 
     if !isExist {
         return "", BitcaskError(fmt.Sprintf("%s: %s", string(key), KeyDoesNotExist))
     }
 
-    buf := make([]byte, rec.valueSize)
-    file, _ := os.Open(path.Join(b.datastorePath, rec.fileId))
-    defer file.Close()
-    file.ReadAt(buf, int64(rec.valuePos))
-    return string(buf), nil
+    return b.readValue(key, rec)
+}
+
+// readValue reads, decrypts and decodes the value a keydir record points to, the caller holds the lock.
+func (b *Bitcask) readValue(key string, rec record) (string, error) {
+    dataRec, err := b.readRecord(key, rec)
+    if err != nil {
+        return "", err
+    }
+
+    dataRec, err = openRecord(b.config.keyring, dataRec)
+    if err != nil {
+        return "", BitcaskError(fmt.Sprintf("%s: %s", key, err))
+    }
+
+    value, err := decodeValue(dataRec)
+    if err != nil {
+        return "", BitcaskError(fmt.Sprintf("%s: %s", key, err))
+    }
+
+    return value, nil
+}
+
+// readRecord reads the data file line a keydir record points to as it is stored.
+// Lines still in the write buffer or in mapped files are copied out of memory instead of read from the file.
+func (b *Bitcask) readRecord(key string, rec record) (dataRecord, error) {
+    buf := b.bufferedLine(rec)
+    if buf == nil {
+        buf = b.mappedLine(rec)
+    }
+    if buf == nil {
+        linePos := rec.valuePos - staticFields * numberFieldSize - rec.keySize
+        buf = make([]byte, staticFields * numberFieldSize + rec.keySize + rec.valueSize)
+        file, err := b.fs.Open(path.Join(b.datastorePath, b.snapshots.fileName(rec.fileId)))
+        if err != nil {
+            return dataRecord{}, err
+        }
+        defer file.Close()
+
+        if _, err := file.ReadAt(buf, int64(linePos)); err != nil {
+            return dataRecord{}, BitcaskError(fmt.Sprintf("%s: %s", key, TruncatedRecord))
+        }
+    }
+
+    dataRec, err := extractFileLine(string(buf))
+    if err != nil {
+        return dataRecord{}, BitcaskError(fmt.Sprintf("%s: %s", key, err))
+    }
+
+    return dataRec, nil
 }
 
 // Put stores a value by key in a bitcask datastore.
-// Sync on each put if SyncOnPut option is set.
+// Sync on each put if SyncOnPut option is set, or wait for a sync shared with concurrent puts if GroupCommit is set.
+// returns an error if the value is longer than the maximum value size.
 func (b *Bitcask) Put(key string, value string) error {
     if b.config.writePermission == ReadOnly {
         return BitcaskError(WriteDenied)
     }
 
-    tstamp := int(time.Now().UnixMicro())
-    n, err := b.writeToActiveFile(string(compressFileLine(key, value, tstamp)))
+    b.mu.Lock()
+    if err := b.checkValueSize(key, len(value)); err != nil {
+        b.mu.Unlock()
+        return err
+    }
+    err := b.put(key, value, int(time.Now().UnixMicro()))
+    seq := b.commit.written
+    b.mu.Unlock()
+
     if err != nil {
         return err
     }
+    return b.commitWrite(seq)
+}
 
-    b.keyDir[key] = record{
-        fileId:    b.activeFile.fileName,
-        valueSize: len(value),
-        valuePos:  b.activeFile.currentPos + staticFields * numberFieldSize + len(key),
-        tstamp:    int(tstamp),
+// put appends a record with the next sequence number and the given timestamp and points the keydir to it, the caller holds the lock.
+func (b *Bitcask) put(key string, value string, tstamp int) error {
+    storedValue, flags, err := b.encodeValue(value)
+    if err != nil {
+        return err
+    }
+    return b.appendRecord(key, value, storedValue, flags, tstamp)
+}
+
+// remove appends a tombstone of key with the given timestamp and removes it from the keydir, the caller holds the lock.
+func (b *Bitcask) remove(key string, tstamp int) error {
+    return b.appendRecord(key, "", "", tombstoneFlag, tstamp)
+}
+
+// appendRecord appends a record holding the encoded value to the active file, the caller holds the lock.
+func (b *Bitcask) appendRecord(key string, value string, storedValue string, flags int, tstamp int) error {
+    storedKey, storedValue, flags, err := b.sealRecord(key, storedValue, flags)
+    if err != nil {
+        return err
+    }
+
+    b.seq++
+    n, err := b.writeToActiveFile(string(compressFileLine(storedKey, storedValue, b.seq, tstamp, flags)))
+    if err != nil {
+        return err
+    }
+
+    if flags & tombstoneFlag != 0 {
+        delete(b.keyDir, key)
+    } else {
+        b.keyDir[key] = record{
+            fileId:    b.activeFile.fileName,
+            valueSize: len(storedValue),
+            valuePos:  b.activeFile.currentPos + staticFields * numberFieldSize + len(storedKey),
+            keySize:   len(storedKey),
+            seq:       b.seq,
+            tstamp:    tstamp,
+        }
     }
 
     b.activeFile.currentPos += n
     b.activeFile.currentSize += n
+    b.commit.written++
+    b.cache.remove(key)
+    b.notifyWatchers(key, value, tstamp, flags)
 
+    // The write is only acknowledged once it is synced.
     if b.config.syncOption == SyncOnPut {
-        b.Sync()
+        return b.sync()
     }
 
     return nil
 }
 
 // Delete removes a key from a bitcask datastore 
-// by appending a special TompStone value that will be deleted in the next merge.
+// by appending a tombstone record that will be deleted in the next merge.
 // returns an error if key does not exist in the bitcask datastore.
 func (b *Bitcask) Delete(key string) error {
     if b.config.writePermission == ReadOnly {
         return BitcaskError(WriteDenied)
     }
 
-    _, err := b.Get(key)
+    b.mu.Lock()
+    _, err := b.get(key)
+    if err == nil {
+        err = b.remove(key, int(time.Now().UnixMicro()))
+    }
+    seq := b.commit.written
+    b.mu.Unlock()
+
     if err != nil {
         return err
     }
-
-    delete(b.keyDir, key)
-
-    return nil
+    return b.commitWrite(seq)
 }
 
 // ListKeys list all keys in a bitcask datastore.
 func (b *Bitcask) ListKeys() []string {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    return b.listKeys()
+}
+
+// listKeys lists all keys, the caller holds the lock.
+func (b *Bitcask) listKeys() []string {
     var list []string
 
     for key := range b.keyDir {
@@ -230,94 +435,19 @@ func (b *Bitcask) ListKeys() []string {
 
 // Fold folds over all key/value pairs in a bitcask datastore.
 // fun is expected to be in the form: F(K, V, Acc) -> Acc
+// The lock is not held while fun runs, so fun may use the bitcask too.
 func (b *Bitcask) Fold(fun func(string, string, any) any, acc any) any {
-    for key := range b.keyDir {
-        value, _ := b.Get(key)
-        acc = fun(key, value, acc)
-    }
+    acc, _ = b.FoldContext(context.Background(), fun, acc)
     return acc
 }
 
 // Merge rearrange the bitcask datastore in a more compact form.
+// Every sealed data file is rewritten, MergeFiles and MergeFragmented merge only some of them.
 // Also produces hintfiles to provide a faster startup.
+// The merged files are only removed once the files replacing them are synced.
 // returns an error if ReadWrite permission is not set.
 func (b *Bitcask) Merge() error {
-    if b.config.writePermission == ReadOnly {
-        return BitcaskError(WriteDenied)
-    }
-
-    var currentPos int = 0
-    var currentSize int = 0
-    newKeyDir := make(map[string]record)
-
-    b.Sync()
-
-    bitcaskDir, _ := os.Open(b.datastorePath)
-    defer bitcaskDir.Close()
-    bitcaskDirContent, _ := bitcaskDir.Readdir(0)
-
-    mergeFileName := strconv.FormatInt(time.Now().UnixMicro(), 10)
-    hintFileName := hintFilePrefix + mergeFileName
-
-    mergeFile, _ := os.OpenFile(path.Join(b.datastorePath, mergeFileName),
-    os.O_CREATE | os.O_RDWR, fileMode)
-
-    hintFile, _ := os.OpenFile(path.Join(b.datastorePath, hintFileName),
-    os.O_CREATE | os.O_RDWR, fileMode)
-
-    for key, recValue := range b.keyDir {
-        if recValue.fileId != b.activeFile.fileName {
-
-            tstamp := time.Now().UnixMicro()
-            value, _ := b.Get(key)
-            fileLine := string(compressFileLine(key, value, int(tstamp)))
-
-            if len(fileLine) + currentSize > maxFileSize {
-                mergeFile.Close()
-                hintFile.Close()
-
-                mergeFileName = strconv.FormatInt(time.Now().UnixMicro(), 10)
-                mergeFile, _ = os.OpenFile(path.Join(b.datastorePath, mergeFileName),
-                os.O_CREATE | os.O_RDWR, fileMode)
-
-                hintFileName = hintFilePrefix + mergeFileName
-                hintFile, _ = os.OpenFile(path.Join(b.datastorePath, hintFileName),
-                os.O_CREATE | os.O_RDWR, fileMode)
-
-                currentPos = 0
-                currentSize = 0
-            }
-
-            newKeyDir[key] = record{
-                fileId:    mergeFileName,
-                valueSize: len(value),
-                valuePos:  currentPos + staticFields * numberFieldSize + len(key),
-                tstamp:    int(tstamp),
-            }
-
-            hintFileLine := buildHintFileLine(newKeyDir[key], key)
-            n, _ := fmt.Fprintln(mergeFile, fileLine)
-            fmt.Fprintln(hintFile, hintFileLine)
-            currentPos += n
-            currentSize += n
-        } else {
-            newKeyDir[key] = b.keyDir[key]
-        }
-    }
-
-    b.keyDir = newKeyDir
-    mergeFile.Close()
-    hintFile.Close()
-
-    for _, file := range bitcaskDirContent {
-        fileName := file.Name()
-        // Skip lock and active files
-        if !strings.HasPrefix(fileName, ".") && b.activeFile.fileName != fileName {
-            os.Remove(path.Join(b.datastorePath, fileName))
-        }
-    }
-
-    return nil
+    return b.MergeContext(context.Background(), nil)
 }
 
 // Sync forces all pending writes to be written into disk.
@@ -327,23 +457,59 @@ func (b *Bitcask) Sync() error {
         return BitcaskError(WriteDenied)
     }
 
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    return b.sync()
+}
+
+// sync flushes the active file to disk, the caller holds the lock.
+// The group commits of the writes appended so far succeed or fail with it.
+func (b *Bitcask) sync() error {
+    if err := b.flush(); err != nil {
+        return err
+    }
+
     err := b.activeFile.file.Sync()
     if err != nil {
+        b.failCommits(b.commit.written, err)
         return err
     }
 
+    b.markSynced(b.commit.written)
     return nil
 }
 
 // Close flushes all pending writes into disk and closes the bitcask datastore.
 func (b *Bitcask) Close() {
+    b.stopSyncInterval()
+    b.stopMergeInterval()
+    // A running merge is finished before the files are closed.
+    b.merges.running.Lock()
+    defer b.merges.running.Unlock()
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    for w := range b.watchers {
+        b.removeWatcher(w)
+    }
+    for s := range b.snapshots.open {
+        b.releaseSnapshot(s)
+    }
+    b.unmapFiles()
+
     if b.config.writePermission == ReadWrite {
-        b.Sync()
+        b.sync()
         b.activeFile.file.Close()
-        os.Remove(path.Join(b.datastorePath, b.lock))
+        b.writeActiveHintFile()
+        writeSequence(b.fs, b.datastorePath, b.nextFileId, b.seq)
+        b.fs.Remove(path.Join(b.datastorePath, b.lock))
     } else {
-        os.Remove(path.Join(b.datastorePath, b.keyDirFile))
-        os.Remove(path.Join(b.datastorePath, b.lock))
+        if b.keyDirFile != "" {
+            b.fs.Remove(path.Join(b.datastorePath, b.keyDirFile))
+        }
+        b.fs.Remove(path.Join(b.datastorePath, b.lock))
     }
     b = nil
 }
@@ -2,79 +2,225 @@ package bitcask
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
-	"time"
 )
 
 // openExistingDatastore opens an existing bitcask datastore.
-func (b *Bitcask) openExistingDatastore() error {
+// A writer takes its lock first, so no other writer opens the datastore while it is migrated from
+// an older version and its keydir is loaded. A reader refuses an older version and takes its lock last,
+// the keydir file of another reader is reused when there is one. The lock is removed if opening fails.
+func (b *Bitcask) openExistingDatastore(ctx context.Context, progress func(Progress)) error {
     if b.lockCheck() == writer {
         return BitcaskError(WriterExist)
     }
 
-    b.buildKeyDir()
-
     if b.config.writePermission == ReadOnly {
+        if err := checkFormat(b.fs, b.datastorePath, false); err != nil {
+            return err
+        }
+        if err := b.buildKeyDir(ctx, progress); err != nil {
+            return err
+        }
         b.buildKeyDirFile()
-        b.lock = readLock + strconv.Itoa(int(time.Now().UnixMicro()))
-        lockFile, _ := os.OpenFile(path.Join(b.datastorePath, b.lock),
-        os.O_CREATE, fileMode)
-        lockFile.Close()
-    } else {
-        b.lock = writeLock + strconv.Itoa(int(time.Now().UnixMicro()))
-        lockFile, _ := os.OpenFile(path.Join(b.datastorePath, b.lock),
-        os.O_CREATE, fileMode)
-        lockFile.Close()
-        b.createActiveFile()
+        b.lock = uniqueName(readLock)
+        return b.fs.Lock(path.Join(b.datastorePath, b.lock))
     }
 
+    b.lock = uniqueName(writeLock)
+    if err := b.fs.Lock(path.Join(b.datastorePath, b.lock)); err != nil {
+        return err
+    }
+    if err := b.openWriter(ctx, progress); err != nil {
+        b.fs.Remove(path.Join(b.datastorePath, b.lock))
+        return err
+    }
     return nil
 }
 
+// openWriter migrates the datastore, loads the keydir and resumes the active file once the writer lock is taken.
+func (b *Bitcask) openWriter(ctx context.Context, progress func(Progress)) error {
+    if err := b.migrateFormat(); err != nil {
+        return err
+    }
+    if err := b.buildKeyDir(ctx, progress); err != nil {
+        return err
+    }
+
+    b.removeSnapshotFiles()
+    b.removeSpoolFiles()
+    b.removeMergeFiles()
+    if err := b.loadSequence(); err != nil {
+        return err
+    }
+    return b.resumeActiveFile()
+}
+
 // createNewDatastore builds new bitcask datastore.
 func (b *Bitcask) createNewDatastore() error {
     if b.config.writePermission == ReadOnly {
         return BitcaskError(CannotCreateBitcask)
     }
 
-    os.MkdirAll(b.datastorePath, dirMode)
+    b.fs.MkdirAll(b.datastorePath)
+    b.lock = uniqueName(writeLock)
+    if err := b.fs.Lock(path.Join(b.datastorePath, b.lock)); err != nil {
+        return err
+    }
+    if err := writeFormat(b.fs, b.datastorePath); err != nil {
+        b.fs.Remove(path.Join(b.datastorePath, b.lock))
+        return err
+    }
     b.keyDir = make(map[string]record)
-    b.createActiveFile()
-    b.lock = writeLock + strconv.Itoa(int(time.Now().UnixMicro()))
-    lockFile, _ := os.OpenFile(path.Join(b.datastorePath, b.lock),
-    os.O_CREATE, fileMode)
-    lockFile.Close()
+    b.nextFileId = 1
+    if err := b.createActiveFile(); err != nil {
+        b.fs.Remove(path.Join(b.datastorePath, b.lock))
+        return err
+    }
 
     return nil
 }
 
-// createActiveFile creates a new active file.
+// resumeActiveFile makes the last data file the active file again when it is not full and ends with a whole record,
+// so reopening a datastore does not leave a new small file behind every time.
+// Otherwise a new active file is created.
+func (b *Bitcask) resumeActiveFile() error {
+    files, err := b.fs.ReadDir(b.datastorePath)
+    if err != nil {
+        return err
+    }
+
+    var lastFile string
+    for _, file := range files {
+        if isDataFile(file.Name()) && (lastFile == "" || compareFileIds(file.Name(), lastFile) > 0) {
+            lastFile = file.Name()
+        }
+    }
+    if lastFile == "" {
+        return b.createActiveFile()
+    }
+
+    fileData, err := readFile(b.fs, path.Join(b.datastorePath, lastFile))
+    if err != nil {
+        return err
+    }
+    if len(fileData) >= maxFileSize || validDataSize(fileData) != len(fileData) {
+        return b.createActiveFile()
+    }
+
+    fileFlags := os.O_RDWR
+    if b.config.syncOption == SyncOnPut {
+        fileFlags |= os.O_SYNC
+    }
+    activeFile, err := b.fs.OpenFile(path.Join(b.datastorePath, lastFile), fileFlags)
+    if err != nil {
+        return err
+    }
+    if _, err := activeFile.Seek(0, io.SeekEnd); err != nil {
+        activeFile.Close()
+        return err
+    }
+
+    // The hint file written when the file was sealed would miss the records appended from now on.
+    if err := b.fs.Remove(path.Join(b.datastorePath, hintFilePrefix + lastFile)); err != nil && !os.IsNotExist(err) {
+        activeFile.Close()
+        return err
+    }
+
+    b.activeFile.file = activeFile
+    b.activeFile.fileName = lastFile
+    b.activeFile.currentPos = len(fileData)
+    b.activeFile.currentSize = len(fileData)
+    b.activeFile.resumedSize = len(fileData)
+
+    return nil
+}
+
+// validDataSize returns the size of the whole valid records at the start of data file content.
+func validDataSize(fileData []byte) int {
+    var currentPos int = 0
+
+    for currentPos < len(fileData) {
+        line, n, err := splitFileLine(fileData[currentPos:])
+        if err != nil {
+            break
+        }
+        if _, err := extractFileLine(line); err != nil {
+            break
+        }
+        currentPos += n
+    }
+
+    return currentPos
+}
+
+// createActiveFile creates a new active file named with the next file id.
 func (b *Bitcask) createActiveFile() error {
-    fileName := strconv.FormatInt(time.Now().UnixMicro(), 10)
+    fileName, err := b.nextFileName()
+    if err != nil {
+        return err
+    }
 
     fileFlags := os.O_CREATE | os.O_RDWR
     if b.config.syncOption == SyncOnPut {
         fileFlags |= os.O_SYNC
     }
 
-    activeFile, err := os.OpenFile(path.Join(b.datastorePath, fileName), fileFlags, fileMode)
+    activeFile, err := b.fs.OpenFile(path.Join(b.datastorePath, fileName), fileFlags)
     if err != nil {
         return err
     }
 
+    // The directory is synced so records synced into the new file are found after a crash.
+    // The replaced active file is flushed, synced and sealed, it is never written again.
+    // It stays the active file when a sync fails, the writes it holds fail their group commits.
+    err = b.fs.SyncDir(b.datastorePath)
+    if err == nil && b.activeFile.file != nil {
+        err = b.sync()
+    }
+    if err != nil {
+        activeFile.Close()
+        b.fs.Remove(path.Join(b.datastorePath, fileName))
+        return err
+    }
+    if b.activeFile.file != nil {
+        b.activeFile.file.Close()
+        b.writeActiveHintFile()
+    }
+
     b.activeFile.file = activeFile
     b.activeFile.fileName = fileName
     b.activeFile.currentPos = 0
     b.activeFile.currentSize = 0
+    b.activeFile.resumedSize = 0
 
     return nil
 }
 
+// writeActiveHintFile writes the hint file of the active file once it is sealed by a rotation or Close,
+// so the next Open does not replay its records.
+// A missing hint file only makes the next Open slower, so failures are ignored.
+func (b *Bitcask) writeActiveHintFile() {
+    if b.activeFile.currentSize == 0 {
+        return
+    }
+
+    fileData, err := readFile(b.fs, path.Join(b.datastorePath, b.activeFile.fileName))
+    if err != nil {
+        return
+    }
+    writeHintFile(b.fs, b.datastorePath, b.activeFile.fileName, fileData)
+}
+
 // writes to the current active file in the bitcask datastore.
+// The line is buffered, the buffer is flushed first when the line would make it larger than writeBufferSize.
 func (b *Bitcask) writeToActiveFile(line string) (int, error) {
     if len(line) + b.activeFile.currentSize > maxFileSize {
         err := b.createActiveFile()
@@ -83,18 +229,59 @@ func (b *Bitcask) writeToActiveFile(line string) (int, error) {
         }
     }
 
-    n, err := b.activeFile.file.Write([]byte(fmt.Sprintln(line)))
-    if err != nil {
-        return 0, err
+    if len(b.activeFile.buf) + len(line) + 1 > writeBufferSize {
+        if err := b.flush(); err != nil {
+            return 0, err
+        }
+    }
+
+    b.activeFile.buf = append(b.activeFile.buf, line...)
+    b.activeFile.buf = append(b.activeFile.buf, '\n')
+
+    return len(line) + 1, nil
+}
+
+// flush writes the buffered records to the active file, the caller holds the lock.
+func (b *Bitcask) flush() error {
+    if len(b.activeFile.buf) == 0 {
+        return nil
+    }
+
+    if _, err := b.activeFile.file.Write(b.activeFile.buf); err != nil {
+        return err
+    }
+    b.activeFile.buf = b.activeFile.buf[:0]
+
+    return nil
+}
+
+// bufferedLine returns the file line a keydir record points to out of the write buffer,
+// nil if the record is already written to the file.
+func (b *Bitcask) bufferedLine(rec record) []byte {
+    if rec.fileId != b.activeFile.fileName || len(b.activeFile.buf) == 0 {
+        return nil
     }
 
-    return n, nil
+    bufferPos := b.activeFile.currentPos - len(b.activeFile.buf)
+    linePos := rec.valuePos - staticFields * numberFieldSize - rec.keySize - bufferPos
+    end := rec.valuePos + rec.valueSize - bufferPos
+    if linePos < 0 || end > len(b.activeFile.buf) {
+        return nil
+    }
+    return b.activeFile.buf[linePos:end:end]
 }
 
 // buildKeyDir establishes keydir associated with a bitcask datastore.
-func (b *Bitcask) buildKeyDir() {
+// Data and hint files are loaded one by one, progress is called after each when not nil.
+// returns an error if a key cannot be decrypted or the error of ctx once it is done.
+func (b *Bitcask) buildKeyDir(ctx context.Context, progress func(Progress)) error {
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+
     if b.config.writePermission == ReadOnly && b.lockCheck() == reader {
-        keyDirData, _ := os.ReadFile(path.Join(b.datastorePath, b.keyDirFileCheck()))
+        keyDirFileName := b.keyDirFileCheck()
+        keyDirData, _ := readFile(b.fs, path.Join(b.datastorePath, keyDirFileName))
 
         b.keyDir = make(map[string]record)
         keyDirScanner := bufio.NewScanner(strings.NewReader(string(keyDirData)))
@@ -102,142 +289,411 @@ func (b *Bitcask) buildKeyDir() {
         for keyDirScanner.Scan() {
             line := keyDirScanner.Text()
 
-            key, fileId, valueSize, valuePos, tstamp := extractKeyDirFileLine(line)
-
-            b.keyDir[key] = record{
-                fileId:    fileId,
-                valueSize: valueSize,
-                valuePos:  valuePos,
-                tstamp:    tstamp,
+            storedKey, recValue, flags, err := extractKeyDirFileLine(line)
+            if err != nil {
+                return BitcaskError(fmt.Sprintf("%s: %s", keyDirFileName, err))
+            }
+            key, err := decodeKey(b.config.keyring, storedKey, flags)
+            if err != nil {
+                return err
             }
+
+            b.keyDir[key] = recValue
         }
     } else {
         var fileNames []string
+        b.tombstones = make(map[string]record)
         hintFilesMap := make(map[string]string)
-        bitcaskDir, _ := os.Open(b.datastorePath)
-        files, _ := bitcaskDir.Readdir(0)
+        files, _ := b.fs.ReadDir(b.datastorePath)
 
+        fileIds := make(map[string]bool)
         for _, file := range files {
             name := file.Name()
-            if strings.HasPrefix(name, hintFilePrefix) {
+            if isHintFile(name) {
                 hintFilesMap[strings.Trim(name, hintFilePrefix)] = name
-                fileNames = append(fileNames, strings.Trim(name, hintFilePrefix))
-            } else {
-                fileNames = append(fileNames, name)
+                fileIds[strings.Trim(name, hintFilePrefix)] = true
+            } else if isDataFile(name) {
+                fileIds[name] = true
             }
         }
+        // A data file with a hint file is loaded once, from its hint file.
+        for fileId := range fileIds {
+            fileNames = append(fileNames, fileId)
+        }
+
+        // Files are replayed oldest first so equal sequence numbers resolve to the latest file.
+        sort.Slice(fileNames, func(i, j int) bool {
+            return compareFileIds(fileNames[i], fileNames[j]) < 0
+        })
 
-        for _, name := range fileNames {
+        for i, name := range fileNames {
+            if err := ctx.Err(); err != nil {
+                b.tombstones = nil
+                return err
+            }
             if hint, isExist := hintFilesMap[name]; isExist {
-                b.extractHintFile(hint)
+                if err := b.extractHintFile(hint); err != nil {
+                    return err
+                }
             } else {
-                var currentPos int = 0
-                fileData, _ := os.ReadFile(path.Join(b.datastorePath, name))
-                fileScanner := bufio.NewScanner(strings.NewReader(string(fileData)))
-                for fileScanner.Scan() {
-                    line := fileScanner.Text()
-                    key, _, tstamp, keySize, valueSize := extractFileLine(line)
-                    b.keyDir[key] = record{
-                    	fileId:    name,
-                    	valueSize: valueSize,
-                    	valuePos:  currentPos + staticFields * numberFieldSize + keySize,
-                    	tstamp:    tstamp,
-                    }
-                    currentPos += len(line) + 1
+                fileData, _ := readFile(b.fs, path.Join(b.datastorePath, name))
+                if _, err := b.replayFileData(name, 0, fileData); err != nil {
+                    return err
                 }
             }
+            if progress != nil {
+                progress(Progress{Done: i + 1, Total: len(fileNames)})
+            }
+        }
+        b.tombstones = nil
+    }
+
+    return nil
+}
+
+// replayFileData applies the records of data file content starting at offset in the file to the keydir.
+// returns the number of bytes of whole valid records, a damaged tail ends the replay.
+// returns an error if a key cannot be decrypted.
+func (b *Bitcask) replayFileData(fileId string, offset int, fileData []byte) (int, error) {
+    var currentPos int = 0
+
+    for currentPos < len(fileData) {
+        line, n, err := splitFileLine(fileData[currentPos:])
+        if err != nil {
+            break
+        }
+        dataRec, err := extractFileLine(line)
+        if err != nil {
+            break
+        }
+        key, err := decodeKey(b.config.keyring, dataRec.key, dataRec.flags)
+        if err != nil {
+            return currentPos, err
         }
+        b.replayRecord(key, record{
+            fileId:    fileId,
+            valueSize: dataRec.valueSize,
+            valuePos:  offset + currentPos + staticFields * numberFieldSize + dataRec.keySize,
+            keySize:   dataRec.keySize,
+            seq:       dataRec.seq,
+            tstamp:    dataRec.tstamp,
+        }, dataRec.flags & tombstoneFlag != 0)
+        currentPos += n
+    }
+
+    return currentPos, nil
+}
+
+// replayRecord points the keydir to a record read from disk unless the keydir has one with a higher sequence number.
+// Tombstones are remembered while replaying so an older copy of a deleted key is not brought back,
+// a newer write of the key makes its tombstone useless since the keydir record then wins over older copies.
+func (b *Bitcask) replayRecord(key string, recValue record, isTombstone bool) {
+    if recValue.seq > b.seq {
+        b.seq = recValue.seq
+    }
+    if current, isExist := b.keyDir[key]; isExist && current.seq > recValue.seq {
+        return
+    }
+    if deleted, isExist := b.tombstones[key]; isExist && deleted.seq > recValue.seq {
+        return
+    }
+
+    // Records replayed into a follower replace values that may be cached.
+    b.cache.remove(key)
+    if isTombstone {
+        delete(b.keyDir, key)
+        b.tombstones[key] = recValue
+        return
+    }
+    delete(b.tombstones, key)
+    b.keyDir[key] = recValue
+}
+
+// compareFileIds orders data file names by their numeric id.
+func compareFileIds(a string, b string) int {
+    if len(a) != len(b) {
+        return len(a) - len(b)
     }
+    return strings.Compare(a, b)
 }
 
 // buildKeyDirFile creates the file used by another processes to read the keydir of the current running procces.
 func (b *Bitcask) buildKeyDirFile() {
-    keyDirFileName := keyDirFilePrefix + strconv.FormatInt(time.Now().UnixMicro(), 10)
+    keyDirFileName := uniqueName(keyDirFilePrefix)
     b.keyDirFile = keyDirFileName
-    keyDirFile, _ := os.Create(path.Join(b.datastorePath, keyDirFileName))
+    keyDirFile, err := b.fs.OpenFile(path.Join(b.datastorePath, keyDirFileName), os.O_CREATE | os.O_TRUNC | os.O_WRONLY)
+    if err != nil {
+        return
+    }
+    defer keyDirFile.Close()
     for key, recValue := range b.keyDir {
+        // Keys are written encrypted when there is a keyring so the file does not leak them.
+        storedKey, flags := b.encodeKey(key)
+
         fileId, _ := strconv.Atoi(recValue.fileId)
         fileIdStr:= padWithZero(fileId)
         valueSizeStr:= padWithZero(recValue.valueSize)
         valuePosStr:= padWithZero(recValue.valuePos)
         tstampStr := padWithZero(recValue.tstamp)
-        keySizeStr := padWithZero(len(key))
+        recKeySizeStr := padWithZero(recValue.keySize)
+        flagsStr := padWithZero(flags)
+        seqStr := padWithZero(recValue.seq)
+        keySizeStr := padWithZero(len(storedKey))
 
-        line := fileIdStr + valueSizeStr + valuePosStr + tstampStr + keySizeStr + key
+        line := fileIdStr + valueSizeStr + valuePosStr + tstampStr + recKeySizeStr + flagsStr + seqStr + keySizeStr + storedKey
         fmt.Fprintln(keyDirFile, line)
     }
 }
 
 // compressFileLine creates a line in a form to be written into files.
-func compressFileLine(key string, value string, tstamp int) []byte {
+// The line starts with a checksum of everything that follows it.
+func compressFileLine(key string, value string, seq int, tstamp int, flags int) []byte {
     tstampStr := padWithZero(tstamp)
     keySize := padWithZero(len([]byte(key)))
     valueSize := padWithZero(len([]byte(value)))
-    return []byte(tstampStr + keySize + valueSize + string(key) + value)
+    flagsStr := padWithZero(flags)
+    seqStr := padWithZero(seq)
+    body := tstampStr + keySize + valueSize + flagsStr + seqStr + string(key) + value
+    crc := padWithZero(int(crc32.ChecksumIEEE([]byte(body))))
+    return []byte(crc + body)
 }
 
 // extractFileLine extracts the data embedded in the file line.
-func extractFileLine(line string) (string, string, int, int, int) {
-    tstamp, _ := strconv.Atoi(line[0: 19])
-    keySize, _ := strconv.Atoi(line[19:38])
-    valueSize, _ := strconv.Atoi(line[38:57])
-    key := line[57:57+keySize]
-    value := line[57+keySize:]
-
-    return key, value, tstamp, keySize, valueSize
+// returns an error if the line framing or checksum is broken.
+func extractFileLine(line string) (dataRecord, error) {
+    header := staticFields * numberFieldSize
+    dataRec, crc, err := extractFileLineHeader(line)
+    if err != nil {
+        return dataRecord{}, err
+    }
+    keySize, valueSize := dataRec.keySize, dataRec.valueSize
+
+    if keySize > len(line) || valueSize > len(line) || header + keySize + valueSize > len(line) {
+        return dataRecord{}, BitcaskError(TruncatedRecord)
+    }
+    if header + keySize + valueSize < len(line) || crc != crc32.ChecksumIEEE([]byte(line[19:])) {
+        return dataRecord{}, BitcaskError(CorruptedRecord)
+    }
+
+    dataRec.key = line[header:header+keySize]
+    dataRec.value = line[header+keySize:]
+
+    return dataRec, nil
+}
+
+// extractFileLineHeader extracts the header fields at the start of a file line.
+// returns the record without key and value and the checksum written in the header.
+func extractFileLineHeader(line string) (dataRecord, uint32, error) {
+    if len(line) < staticFields * numberFieldSize {
+        return dataRecord{}, 0, BitcaskError(TruncatedRecord)
+    }
+
+    crc, crcErr := strconv.Atoi(line[0:19])
+    tstamp, tstampErr := strconv.Atoi(line[19:38])
+    keySize, keySizeErr := strconv.Atoi(line[38:57])
+    valueSize, valueSizeErr := strconv.Atoi(line[57:76])
+    flags, flagsErr := strconv.Atoi(line[76:95])
+    seq, seqErr := strconv.Atoi(line[95:114])
+    if crcErr != nil || tstampErr != nil || keySizeErr != nil || valueSizeErr != nil || flagsErr != nil || seqErr != nil ||
+    keySize < 0 || valueSize < 0 {
+        return dataRecord{}, 0, BitcaskError(CorruptedRecord)
+    }
+
+    return dataRecord{
+        seq:       seq,
+        tstamp:    tstamp,
+        flags:     flags,
+        keySize:   keySize,
+        valueSize: valueSize,
+    }, uint32(crc), nil
+}
+
+// splitFileLine cuts the first file line out of data using the sizes in its header.
+// returns the line without its newline and the number of bytes it occupies in data.
+func splitFileLine(data []byte) (string, int, error) {
+    header := staticFields * numberFieldSize
+    if len(data) < header {
+        return "", 0, BitcaskError(TruncatedRecord)
+    }
+
+    keySize, keySizeErr := strconv.Atoi(string(data[38:57]))
+    valueSize, valueSizeErr := strconv.Atoi(string(data[57:76]))
+    if keySizeErr != nil || valueSizeErr != nil || keySize < 0 || valueSize < 0 {
+        return "", 0, BitcaskError(CorruptedRecord)
+    }
+
+    if keySize >= len(data) || valueSize >= len(data) || header + keySize + valueSize >= len(data) {
+        return "", 0, BitcaskError(TruncatedRecord)
+    }
+
+    end := header + keySize + valueSize
+    if data[end] != '\n' {
+        return "", 0, BitcaskError(CorruptedRecord)
+    }
+
+    return string(data[:end]), end + 1, nil
 }
 
 // extractKeyDirFileLine extracts the keydir data from keyDirFile.
-func extractKeyDirFileLine(line string) (string, string, int, int, int) {
-    fileId, _ := strconv.Atoi(line[0:19])
-    valueSize, _ := strconv.Atoi(line[19:38])
-    valuePos, _ := strconv.Atoi(line[38:57])
-    tstamp, _ := strconv.Atoi(line[57:76])
-    keySize, _ := strconv.Atoi(line[76:95])
-    key := line[95:95+keySize]
-
-    return key, strconv.Itoa(fileId), valueSize, valuePos, tstamp
+// returns the key as it is written in the file and the flags telling whether it is encrypted.
+// returns an error if the line is cut or its fields are not numbers.
+func extractKeyDirFileLine(line string) (string, record, int, error) {
+    if len(line) < 152 {
+        return "", record{}, 0, BitcaskError(CorruptedRecord)
+    }
+
+    fields := make([]int, 8)
+    for i := range fields {
+        field, err := strconv.Atoi(line[i*19:(i+1)*19])
+        if err != nil {
+            return "", record{}, 0, BitcaskError(CorruptedRecord)
+        }
+        fields[i] = field
+    }
+    fileId, valueSize, valuePos, tstamp, recKeySize, flags, seq, keySize :=
+    fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6], fields[7]
+    if keySize != len(line) - 152 {
+        return "", record{}, 0, BitcaskError(CorruptedRecord)
+    }
+    key := line[152:]
+
+    recValue := record{
+        fileId:    strconv.Itoa(fileId),
+        valueSize: valueSize,
+        valuePos:  valuePos,
+        keySize:   recKeySize,
+        seq:       seq,
+        tstamp:    tstamp,
+    }
+
+    return key, recValue, flags, nil
 }
 
 // buildHintFileLine creates a line to be written in hint files.
-func buildHintFileLine(recValue record, key string) string {
+// key is written as it is stored in the data file and flags are the data file line flags.
+func buildHintFileLine(recValue record, key string, flags int) string {
     tstamp := padWithZero(recValue.tstamp)
     keySize := padWithZero(len(key))
     valueSize := padWithZero(recValue.valueSize)
     valuePos := padWithZero(recValue.valuePos)
-    return tstamp + keySize + valueSize + valuePos + key
+    flagsStr := padWithZero(flags)
+    seq := padWithZero(recValue.seq)
+    return tstamp + keySize + valueSize + valuePos + flagsStr + seq + key
 }
 
 // extractHintFile extracts the data from hint files.
-func (b *Bitcask) extractHintFile(hintName string) {
-    hintFileData, _ := os.ReadFile(path.Join(b.datastorePath, hintName))
+// returns an error if a key cannot be decrypted.
+func (b *Bitcask) extractHintFile(hintName string) error {
+    hintFileData, _ := readFile(b.fs, path.Join(b.datastorePath, hintName))
     hintFileScanner := bufio.NewScanner(strings.NewReader(string(hintFileData)))
 
     fileId := strings.Trim(hintName, hintFilePrefix)
 
     for hintFileScanner.Scan() {
-        line := hintFileScanner.Text()
-        tstamp, _ := strconv.Atoi(line[0:19])
-        keySize, _ := strconv.Atoi(line[19:38])
-        valueSize, _ := strconv.Atoi(line[38:57])
-        valuePos, _ := strconv.Atoi(line[57:76])
-        key := line[76:76+keySize]
-
-        b.keyDir[key] = record{
-        	fileId:    fileId,
-        	valueSize: valueSize,
-        	valuePos:  valuePos,
-        	tstamp:    tstamp,
+        storedKey, recValue, flags, err := extractHintFileLine(hintFileScanner.Text(), fileId)
+        if err != nil {
+            break
+        }
+        key, err := decodeKey(b.config.keyring, storedKey, flags)
+        if err != nil {
+            return err
+        }
+        b.replayRecord(key, recValue, recValue.valueSize == hintTombstoneSize)
+    }
+
+    return nil
+}
+
+// extractHintFileLine extracts the keydir record stored in a hint file line.
+// returns the key as it is stored in the data file and the data file line flags.
+func extractHintFileLine(line string, fileId string) (string, record, int, error) {
+    if len(line) < 114 {
+        return "", record{}, 0, BitcaskError(TruncatedRecord)
+    }
+
+    tstamp, tstampErr := strconv.Atoi(line[0:19])
+    keySize, keySizeErr := strconv.Atoi(line[19:38])
+    valueSize, valueSizeErr := strconv.Atoi(line[38:57])
+    valuePos, valuePosErr := strconv.Atoi(line[57:76])
+    flags, flagsErr := strconv.Atoi(line[76:95])
+    seq, seqErr := strconv.Atoi(line[95:114])
+    if tstampErr != nil || keySizeErr != nil || valueSizeErr != nil || valuePosErr != nil || flagsErr != nil || seqErr != nil ||
+    keySize != len(line) - 114 {
+        return "", record{}, 0, BitcaskError(CorruptedRecord)
+    }
+
+    recValue := record{
+        fileId:    fileId,
+        valueSize: valueSize,
+        valuePos:  valuePos,
+        keySize:   keySize,
+        seq:       seq,
+        tstamp:    tstamp,
+    }
+
+    return line[114:], recValue, flags, nil
+}
+
+// writeHintFile writes a hint file for the given data file content.
+// Only the last record of each key in the file is kept, deleted keys keep their tombstone.
+func writeHintFile(fsys FileSystem, dirPath string, fileId string, fileData []byte) error {
+    var currentPos int = 0
+    var keys []string
+    entries := make(map[string]record)
+    entryFlags := make(map[string]int)
+
+    for currentPos < len(fileData) {
+        line, n, err := splitFileLine(fileData[currentPos:])
+        if err != nil {
+            break
         }
+        dataRec, err := extractFileLine(line)
+        if err != nil {
+            break
+        }
+        if _, isExist := entries[dataRec.key]; !isExist {
+            keys = append(keys, dataRec.key)
+        }
+        valueSize := dataRec.valueSize
+        if dataRec.flags & tombstoneFlag != 0 {
+            valueSize = hintTombstoneSize
+        }
+        entries[dataRec.key] = record{
+            fileId:    fileId,
+            valueSize: valueSize,
+            valuePos:  currentPos + staticFields * numberFieldSize + dataRec.keySize,
+            keySize:   dataRec.keySize,
+            seq:       dataRec.seq,
+            tstamp:    dataRec.tstamp,
+        }
+        entryFlags[dataRec.key] = dataRec.flags
+        currentPos += n
+    }
+
+    var hintData strings.Builder
+    for _, key := range keys {
+        fmt.Fprintln(&hintData, buildHintFileLine(entries[key], key, entryFlags[key]))
     }
+
+    return writeHintData(fsys, dirPath, fileId, hintData.String())
+}
+
+// writeHintData writes the hint file of a data file with the given hint lines.
+// The hint file is written under a temporary name and renamed, so a crash never leaves a partial one.
+func writeHintData(fsys FileSystem, dirPath string, fileId string, hintData string) error {
+    hintPath := path.Join(dirPath, hintFilePrefix + fileId)
+    if err := writeSyncedFile(fsys, hintPath + ".tmp", hintData); err != nil {
+        fsys.Remove(hintPath + ".tmp")
+        return err
+    }
+
+    return renameSynced(fsys, hintPath + ".tmp", hintPath)
 }
 
 // lockCheck checks if exist another process in the bitcask datastore.
 func (b *Bitcask) lockCheck() processAccess {
-    bitcaskDir, _ := os.Open(b.datastorePath)
+    files, _ := b.fs.ReadDir(b.datastorePath)
 
-    files, _ := bitcaskDir.Readdir(0)
-    
     for _, file := range files {
         if strings.HasPrefix(file.Name(), readLock) {
             return reader
@@ -251,10 +707,8 @@ func (b *Bitcask) lockCheck() processAccess {
 // keyDirFileCheck checks if keydir file associated with another existing process exists.
 func (b *Bitcask) keyDirFileCheck() string {
     var fileName string
-    bitcaskDir, _ := os.Open(b.datastorePath)
+    files, _ := b.fs.ReadDir(b.datastorePath)
 
-    files, _ := bitcaskDir.Readdir(0)
-    
     for _, file := range files {
         if strings.HasPrefix(file.Name(), keyDirFilePrefix) {
             fileName = file.Name()
@@ -264,6 +718,25 @@ func (b *Bitcask) keyDirFileCheck() string {
     return fileName
 }
 
+// isDataFile reports whether the file name belongs to a data file.
+func isDataFile(name string) bool {
+    if name == "" {
+        return false
+    }
+    for _, c := range name {
+        if c < '0' || c > '9' {
+            return false
+        }
+    }
+    return true
+}
+
+// isHintFile reports whether the file name belongs to a hint file.
+func isHintFile(name string) bool {
+    return strings.HasPrefix(name, hintFilePrefix) && isDataFile(strings.TrimPrefix(name, hintFilePrefix))
+}
+
+// padWithZero formats the number in a fixed size field.
 func padWithZero(val int) string {
     return fmt.Sprintf("%019d", val)
 }
@@ -73,6 +73,20 @@ func TestOpen(t *testing.T) {
         os.RemoveAll(testBitcaskPath)
     })
 
+    t.Run("reader with a damaged keydir file of another reader", func(t *testing.T) {
+        b1, _ := Open(testBitcaskPath, ReadWrite)
+        b1.Put("key1", "value1")
+        b1.Close()
+
+        b2, _ := Open(testBitcaskPath)
+        os.WriteFile(path.Join(testBitcaskPath, b2.keyDirFile), []byte("short line\n"), fileMode)
+
+        _, err := Open(testBitcaskPath)
+        assertError(t, err, b2.keyDirFile + ": " + CorruptedRecord)
+        b2.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
     t.Run("open existing bitcask with hint files in it", func(t *testing.T) {
         b1, _ := Open(testBitcaskPath, ReadWrite, SyncOnPut)
 
@@ -147,6 +161,19 @@ func TestGet(t *testing.T) {
         assertError(t, err, want)
         os.RemoveAll(testBitcaskPath)
     })
+
+    t.Run("has key", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        b.Put("key2", "value2")
+        b.Delete("key2")
+
+        if !b.Has("key1") || b.Has("key2") || b.Has("unknown key") {
+            t.Errorf("expected only key1 to exist")
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
 }
 
 func TestPut(t *testing.T) {
@@ -183,6 +210,36 @@ func TestDelete(t *testing.T) {
         os.RemoveAll(testBitcaskPath)
     })
 
+    t.Run("deleted key stays deleted after reopen", func(t *testing.T) {
+        b1, _ := Open(testBitcaskPath, ReadWrite)
+        b1.Put("key12", "value12345")
+        b1.Merge()
+        b1.Delete("key12")
+        b1.Close()
+
+        b2, _ := Open(testBitcaskPath)
+        _, err := b2.Get("key12")
+        b2.Close()
+
+        assertError(t, err, "key12: key does not exist")
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("value looking like an old tombstone is kept", func(t *testing.T) {
+        b1, _ := Open(testBitcaskPath, ReadWrite)
+        b1.Put("key12", "DELETE THIS VALUE")
+        got, _ := b1.Get("key12")
+        assertString(t, got, "DELETE THIS VALUE")
+        b1.Close()
+
+        b2, _ := Open(testBitcaskPath)
+        got, _ = b2.Get("key12")
+        b2.Close()
+
+        assertString(t, got, "DELETE THIS VALUE")
+        os.RemoveAll(testBitcaskPath)
+    })
+
     t.Run("delete not existing key", func(t *testing.T) {
         b, _ := Open(testBitcaskPath, ReadWrite, SyncOnDemand)
         err := b.Delete("key12")
@@ -299,6 +356,71 @@ func TestSync(t *testing.T) {
         os.RemoveAll(testBitcaskPath)
     })
 
+    t.Run("reopen resumes the last data file", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        b.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        b.Put("key2", "value2")
+        b.Close()
+
+        dataFiles, _ := listDataFiles(testBitcaskPath)
+        hintFiles, _ := listFilesWithPrefix(testBitcaskPath, hintFilePrefix)
+        if len(dataFiles) != 1 || len(hintFiles) != 1 {
+            t.Errorf("got %d data files and %d hint files, want 1 and 1", len(dataFiles), len(hintFiles))
+        }
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        got, _ := b.Get("key1")
+        assertString(t, got, "value1")
+        got, _ = b.Get("key2")
+        assertString(t, got, "value2")
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("reopen after a damaged tail starts a new data file", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        activeFile := b.activeFile.fileName
+        b.Close()
+
+        file, _ := os.OpenFile(path.Join(testBitcaskPath, activeFile), os.O_WRONLY | os.O_APPEND, fileMode)
+        file.WriteString("torn record")
+        file.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        if b.activeFile.fileName == activeFile {
+            t.Errorf("expected a new active file after a damaged tail")
+        }
+        got, _ := b.Get("key1")
+        assertString(t, got, "value1")
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("get records still in the write buffer", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        b.Put("key2", "value2")
+
+        if len(b.activeFile.buf) == 0 {
+            t.Fatalf("expected records to be buffered")
+        }
+        got, _ := b.Get("key2")
+        assertString(t, got, "value2")
+
+        b.Sync()
+        if len(b.activeFile.buf) != 0 {
+            t.Errorf("expected sync to flush the buffer")
+        }
+        got, _ = b.Get("key1")
+        assertString(t, got, "value1")
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
     t.Run("sync with no write permission", func(t *testing.T) {
         b1, _ := Open(testBitcaskPath, ReadWrite)
         b1.Close()
@@ -311,6 +433,35 @@ func TestSync(t *testing.T) {
     })
 }
 
+func BenchmarkPut(b *testing.B) {
+    benchmarkPut(b)
+}
+
+func BenchmarkPutSyncOnPut(b *testing.B) {
+    benchmarkPut(b, SyncOnPut)
+}
+
+func BenchmarkPutGroupCommit(b *testing.B) {
+    benchmarkPut(b, GroupCommit)
+}
+
+// benchmarkPut measures bulk puts of small values with the given sync options.
+func benchmarkPut(b *testing.B, opts ...ConfigOpt) {
+    store, err := Open(testBitcaskPath, append(opts, ReadWrite)...)
+    if err != nil {
+        b.Fatal(err)
+    }
+    defer os.RemoveAll(testBitcaskPath)
+    defer store.Close()
+
+    value := fmt.Sprintf("%0100d", 0)
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        store.Put("key" + strconv.Itoa(i), value)
+    }
+}
+
 func assertError(t testing.TB, err error, want string) {
     t.Helper()
     if err == nil {
@@ -325,3 +476,11 @@ func assertString(t testing.TB, got, want string) {
         t.Errorf("got:\n%q\nwant:\n%q", got, want)
     }
 }
+
+// fillActiveFile writes records until the active file rotates, so the records written before are in a sealed file.
+func fillActiveFile(b *Bitcask) {
+    activeFile := b.activeFile.fileName
+    for i := 0; b.activeFile.fileName == activeFile; i++ {
+        b.Put(fmt.Sprintf("fill%d", i), "value")
+    }
+}
@@ -0,0 +1,139 @@
+package bitcask
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BulkIterator yields the key/value pairs written by BulkLoad.
+type BulkIterator interface {
+    // Next returns the next pair, io.EOF once all pairs were returned.
+    Next() (string, string, error)
+}
+
+// bulkWriter fills the data files written by BulkLoad.
+type bulkWriter struct {
+    fs FileSystem
+    dirPath string
+    nextFileId int
+    seq int
+    data []byte
+}
+
+// BulkLoad writes every pair of it into new data files of the datastore in dirPath,
+// each with its hint file, so Open loads them without replaying the records.
+// The directory is created if it does not exist, pairs loaded later win over earlier ones and existing keys.
+// The files take the next file ids of the datastore and the records its next sequence numbers.
+// Values are written as they are, a store opened with compression or encryption encodes them at the next merge.
+// returns an error if a process has the datastore open for writing or it has the format of an older version.
+func BulkLoad(dirPath string, it BulkIterator) error {
+    return bulkLoad(OSFileSystem, dirPath, it)
+}
+
+// bulkLoad writes the pairs of it into the datastore in dirPath of fsys like BulkLoad.
+func bulkLoad(fsys FileSystem, dirPath string, it BulkIterator) error {
+    if err := fsys.MkdirAll(dirPath); err != nil {
+        return BitcaskError(fmt.Sprintf("%s: %s", dirPath, CannotOpenThisDir))
+    }
+
+    files, err := fsys.ReadDir(dirPath)
+    if err != nil {
+        return BitcaskError(fmt.Sprintf("%s: %s", dirPath, CannotOpenThisDir))
+    }
+    var lastFile string
+    for _, file := range files {
+        if strings.HasPrefix(file.Name(), writeLock) {
+            return BitcaskError(WriterExist)
+        }
+        if isDataFile(file.Name()) && (lastFile == "" || compareFileIds(file.Name(), lastFile) > 0) {
+            lastFile = file.Name()
+        }
+    }
+
+    // The write lock keeps other writers out while the files are written.
+    lock := path.Join(dirPath, uniqueName(writeLock))
+    if err := fsys.Lock(lock); err != nil {
+        return err
+    }
+    defer fsys.Remove(lock)
+
+    if err := checkFormat(fsys, dirPath, true); err != nil {
+        return err
+    }
+
+    nextFileId, seq, err := readSequence(fsys, dirPath)
+    if err != nil {
+        return err
+    }
+    // A writer that did not close cleanly may have written records past the persisted sequence number,
+    // they can only be in the last data file.
+    if lastFile != "" {
+        fileData, err := readFile(fsys, path.Join(dirPath, lastFile))
+        if err != nil {
+            return err
+        }
+        if last := lastSequence(fileData); last > seq {
+            seq = last
+        }
+    }
+
+    w := &bulkWriter{fs: fsys, dirPath: dirPath, nextFileId: nextFileId, seq: seq}
+    for {
+        key, value, err := it.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return err
+        }
+        if err := w.add(key, value); err != nil {
+            return err
+        }
+    }
+
+    if err := w.seal(); err != nil {
+        return err
+    }
+
+    return writeSequence(fsys, dirPath, w.nextFileId, w.seq)
+}
+
+// add appends a record, sealing the current file first when the record would make it too large.
+func (w *bulkWriter) add(key string, value string) error {
+    // Sequence numbers only go up so the last pair of a key wins on replay.
+    w.seq++
+    line := compressFileLine(key, value, w.seq, int(time.Now().UnixMicro()), 0)
+    if len(w.data) > 0 && len(w.data) + len(line) + 1 > maxFileSize {
+        if err := w.seal(); err != nil {
+            return err
+        }
+    }
+
+    w.data = append(w.data, line...)
+    w.data = append(w.data, '\n')
+    return nil
+}
+
+// seal writes the current data file with its hint file.
+func (w *bulkWriter) seal() error {
+    if len(w.data) == 0 {
+        return nil
+    }
+
+    fileId := strconv.Itoa(w.nextFileId)
+    w.nextFileId++
+
+    if err := writeSyncedFile(w.fs, path.Join(w.dirPath, fileId), string(w.data)); err != nil {
+        return err
+    }
+    if err := writeHintFile(w.fs, w.dirPath, fileId, w.data); err != nil {
+        return err
+    }
+
+    w.data = w.data[:0]
+    return nil
+}
@@ -0,0 +1,70 @@
+package bitcask
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+// countIterator yields n pairs keyN/valueN.
+type countIterator struct {
+    i int
+    n int
+}
+
+func (c *countIterator) Next() (string, string, error) {
+    if c.i == c.n {
+        return "", "", io.EOF
+    }
+    c.i++
+    return fmt.Sprintf("key%d", c.i), fmt.Sprintf("value%d", c.i), nil
+}
+
+func TestBulkLoad(t *testing.T) {
+    t.Run("loaded store opens from hint files", func(t *testing.T) {
+        if err := BulkLoad(testBitcaskPath, &countIterator{n: 1000}); err != nil {
+            t.Fatal(err)
+        }
+
+        dataFiles, _ := listDataFiles(testBitcaskPath)
+        hintFiles, _ := listFilesWithPrefix(testBitcaskPath, hintFilePrefix)
+        if len(dataFiles) < 2 || len(hintFiles) != len(dataFiles) {
+            t.Errorf("got %d data files and %d hint files", len(dataFiles), len(hintFiles))
+        }
+
+        b, err := Open(testBitcaskPath, ReadWrite)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(b.ListKeys()) != 1000 {
+            t.Errorf("got %d keys, want 1000", len(b.ListKeys()))
+        }
+        got, _ := b.Get("key500")
+        assertString(t, got, "value500")
+
+        b.Put("key500", "changed")
+        b.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        got, _ = b.Get("key500")
+        assertString(t, got, "changed")
+        b.Close()
+
+        report, _ := Verify(testBitcaskPath)
+        if !report.Healthy() {
+            t.Errorf("expected healthy datastore, got: %+v", report)
+        }
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("refuses to load while a writer is open", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+
+        err := BulkLoad(testBitcaskPath, &countIterator{n: 1})
+        assertError(t, err, WriterExist)
+
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
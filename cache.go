@@ -0,0 +1,117 @@
+package bitcask
+
+import (
+	"container/list"
+	"sync"
+)
+
+// valueCache keeps the most recently read values up to a total size in bytes.
+// It has its own lock since Get fills it while holding only the read lock.
+type valueCache struct {
+    mu sync.Mutex
+    maxBytes int
+    bytes int
+    entries map[string]*list.Element
+    lru *list.List
+    hits int64
+    misses int64
+}
+
+// cacheEntry is a cached value, kept in the lru list most recently used first.
+type cacheEntry struct {
+    key string
+    value string
+}
+
+// SetCacheSize keeps up to maxBytes of the most recently read values in memory in front of Get.
+// A size of 0 turns the cache off, which is the default.
+func (b *Bitcask) SetCacheSize(maxBytes int) {
+    b.cache.mu.Lock()
+    defer b.cache.mu.Unlock()
+
+    b.cache.maxBytes = maxBytes
+    if b.cache.entries == nil {
+        b.cache.entries = make(map[string]*list.Element)
+        b.cache.lru = list.New()
+    }
+    b.cache.evict()
+}
+
+// get returns the cached value of key and counts the hit or miss when the cache is on.
+func (c *valueCache) get(key string) (string, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.maxBytes == 0 {
+        return "", false
+    }
+
+    elem, isExist := c.entries[key]
+    if !isExist {
+        c.misses++
+        return "", false
+    }
+    c.hits++
+    c.lru.MoveToFront(elem)
+    return elem.Value.(*cacheEntry).value, true
+}
+
+// add caches the value of key when the cache is on, values larger than the whole cache are not kept.
+func (c *valueCache) add(key string, value string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.entries == nil || len(key) + len(value) > c.maxBytes {
+        return
+    }
+
+    c.removeEntry(key)
+    c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: value})
+    c.bytes += len(key) + len(value)
+    c.evict()
+}
+
+// remove drops the cached value of key after it was written or deleted.
+func (c *valueCache) remove(key string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    c.removeEntry(key)
+}
+
+// clear drops all cached values.
+func (c *valueCache) clear() {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    for key := range c.entries {
+        c.removeEntry(key)
+    }
+}
+
+// counters returns the number of hits and misses so far.
+func (c *valueCache) counters() (int64, int64) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    return c.hits, c.misses
+}
+
+// removeEntry drops the cached value of key, the caller holds the cache lock.
+func (c *valueCache) removeEntry(key string) {
+    elem, isExist := c.entries[key]
+    if !isExist {
+        return
+    }
+    entry := c.lru.Remove(elem).(*cacheEntry)
+    delete(c.entries, key)
+    c.bytes -= len(entry.key) + len(entry.value)
+}
+
+// evict drops the least recently used values until the cache fits its size, the caller holds the cache lock.
+func (c *valueCache) evict() {
+    for c.bytes > c.maxBytes {
+        entry := c.lru.Back().Value.(*cacheEntry)
+        c.removeEntry(entry.key)
+    }
+}
@@ -0,0 +1,81 @@
+package bitcask
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+    t.Run("hits and misses are counted", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.SetCacheSize(1024)
+        b.Put("key1", "value1")
+
+        b.Get("key1")
+        b.Get("key1")
+        b.Get("key1")
+
+        stats, _ := b.Stats()
+        if stats.CacheHits != 2 || stats.CacheMisses != 1 {
+            t.Errorf("got %d hits and %d misses, want 2 and 1", stats.CacheHits, stats.CacheMisses)
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("writes invalidate cached values", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.SetCacheSize(1024)
+        b.Put("key1", "value1")
+        b.Get("key1")
+
+        b.Put("key1", "value2")
+        got, _ := b.Get("key1")
+        assertString(t, got, "value2")
+
+        b.Delete("key1")
+        _, err := b.Get("key1")
+        assertError(t, err, "key1: " + KeyDoesNotExist)
+
+        b.Put("key2", "value2")
+        b.Get("key2")
+        b.Merge()
+        if len(b.cache.entries) != 0 {
+            t.Errorf("expected merge to clear the cache, got %d entries", len(b.cache.entries))
+        }
+        got, _ = b.Get("key2")
+        assertString(t, got, "value2")
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("least recently used values are evicted", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.SetCacheSize(2 * len("key1value1"))
+        b.Put("key1", "value1")
+        b.Put("key2", "value2")
+        b.Put("key3", "value3")
+
+        b.Get("key1")
+        b.Get("key2")
+        b.Get("key1")
+        b.Get("key3")
+
+        if _, isExist := b.cache.entries["key2"]; isExist {
+            t.Errorf("expected key2 to be evicted")
+        }
+        if _, isExist := b.cache.entries["key1"]; !isExist {
+            t.Errorf("expected key1 to stay cached")
+        }
+        if b.cache.bytes > b.cache.maxBytes {
+            t.Errorf("cache holds %d bytes over its size %d", b.cache.bytes, b.cache.maxBytes)
+        }
+
+        b.SetCacheSize(0)
+        if len(b.cache.entries) != 0 {
+            t.Errorf("expected turning the cache off to empty it")
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
@@ -0,0 +1,148 @@
+// Command bitcask runs maintenance tasks on bitcask datastores.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"bitcask"
+	"bitcask/resp"
+	"bitcask/server"
+)
+
+const usage = `usage: bitcask <command> [arguments]
+
+commands:
+    verify [-repair] <dir>    check data and hint files of an offline datastore
+    serve [-addr addr] [-readonly] <dir>
+                              serve the datastore as a REST key/value service
+    resp [-addr addr] [-readonly] <dir>
+                              serve the datastore to redis clients
+`
+
+func main() {
+    if len(os.Args) < 2 {
+        fmt.Fprint(os.Stderr, usage)
+        os.Exit(2)
+    }
+
+    switch os.Args[1] {
+    case "verify":
+        os.Exit(verify(os.Args[2:]))
+    case "serve":
+        os.Exit(serve(os.Args[2:]))
+    case "resp":
+        os.Exit(serveResp(os.Args[2:]))
+    default:
+        fmt.Fprint(os.Stderr, usage)
+        os.Exit(2)
+    }
+}
+
+// verify runs the verify command and returns the process exit code.
+func verify(args []string) int {
+    flags := flag.NewFlagSet("verify", flag.ExitOnError)
+    repair := flags.Bool("repair", false, "truncate damaged tails, regenerate hints and remove orphaned files")
+    flags.Parse(args)
+
+    if flags.NArg() != 1 {
+        fmt.Fprint(os.Stderr, usage)
+        return 2
+    }
+
+    var report bitcask.VerifyReport
+    var err error
+    if *repair {
+        report, err = bitcask.Repair(flags.Arg(0))
+    } else {
+        report, err = bitcask.Verify(flags.Arg(0))
+    }
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        return 1
+    }
+
+    fmt.Printf("data files: %d, hint files: %d, records: %d\n", report.DataFiles, report.HintFiles, report.Records)
+    for _, problem := range report.Problems {
+        fmt.Printf("%s@%d: %s\n", problem.File, problem.Offset, problem.Reason)
+    }
+    for _, name := range report.OrphanedLocks {
+        fmt.Printf("orphaned lock: %s\n", name)
+    }
+    for _, name := range report.OrphanedKeyDirFiles {
+        fmt.Printf("orphaned keydir file: %s\n", name)
+    }
+    for _, name := range report.InUse {
+        fmt.Printf("in use by a running process: %s\n", name)
+    }
+    for _, name := range report.Repaired {
+        fmt.Printf("repaired: %s\n", name)
+    }
+
+    if !report.Healthy() && !*repair {
+        return 1
+    }
+    return 0
+}
+
+// serve runs the serve command and returns the process exit code.
+func serve(args []string) int {
+    flags := flag.NewFlagSet("serve", flag.ExitOnError)
+    addr := flags.String("addr", ":8080", "address to listen on")
+    readOnly := flags.Bool("readonly", false, "open the datastore with read only permission")
+    flags.Parse(args)
+
+    if flags.NArg() != 1 {
+        fmt.Fprint(os.Stderr, usage)
+        return 2
+    }
+
+    store, err := openStore(flags.Arg(0), *readOnly)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        return 1
+    }
+    defer store.Close()
+
+    if err := http.ListenAndServe(*addr, server.New(store)); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        return 1
+    }
+    return 0
+}
+
+// serveResp runs the resp command and returns the process exit code.
+func serveResp(args []string) int {
+    flags := flag.NewFlagSet("resp", flag.ExitOnError)
+    addr := flags.String("addr", ":6379", "address to listen on")
+    readOnly := flags.Bool("readonly", false, "open the datastore with read only permission")
+    flags.Parse(args)
+
+    if flags.NArg() != 1 {
+        fmt.Fprint(os.Stderr, usage)
+        return 2
+    }
+
+    store, err := openStore(flags.Arg(0), *readOnly)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        return 1
+    }
+    defer store.Close()
+
+    if err := resp.New(store).ListenAndServe(*addr); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        return 1
+    }
+    return 0
+}
+
+// openStore opens the datastore with the permission asked for on the command line.
+func openStore(dirPath string, readOnly bool) (*bitcask.Bitcask, error) {
+    if readOnly {
+        return bitcask.Open(dirPath)
+    }
+    return bitcask.Open(dirPath, bitcask.ReadWrite)
+}
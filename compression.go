@@ -0,0 +1,162 @@
+package bitcask
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+    // Error message when a record is compressed with a codec that is not registered.
+    UnknownCodec = "unknown codec"
+)
+
+const (
+    // Values shorter than this are stored as they are when CompressValues is set.
+    defaultCompressMinSize = 256
+
+    // Id of FlateCodec.
+    flateCodecId = 1
+
+    // Position and mask of the codec id in the record flags.
+    codecShift = 8
+    codecMask = 0xff
+)
+
+// Codec compresses values stored in a bitcask datastore.
+// ID is written in the header of every record compressed by the codec, so it must never change.
+// IDs go from 1 to 255, 0 means the value is not compressed.
+type Codec interface {
+    ID() int
+    Compress(src []byte) ([]byte, error)
+    Decompress(src []byte) ([]byte, error)
+}
+
+// flateCodec compresses values with compress/flate.
+type flateCodec struct{}
+
+// FlateCodec is the built-in codec based on compress/flate.
+var FlateCodec Codec = flateCodec{}
+
+var (
+    codecsMu sync.RWMutex
+    codecs = map[int]Codec{flateCodecId: FlateCodec}
+)
+
+// RegisterCodec makes a codec available to read and write records.
+// Every process reading a datastore must register the codecs its records were written with.
+// It panics if the id is out of range or already registered.
+func RegisterCodec(codec Codec) {
+    codecsMu.Lock()
+    defer codecsMu.Unlock()
+
+    registerCodec(codec)
+}
+
+// registerCodec registers a codec like RegisterCodec, the caller holds codecsMu.
+func registerCodec(codec Codec) {
+    id := codec.ID()
+    if id < 1 || id > codecMask {
+        panic(fmt.Sprintf("bitcask: codec id %d out of range", id))
+    }
+    if _, isExist := codecs[id]; isExist {
+        panic(fmt.Sprintf("bitcask: codec id %d registered twice", id))
+    }
+    codecs[id] = codec
+}
+
+// SetCompression makes later writes compress values of at least minSize bytes with codec.
+// A nil codec stops compression, values already written stay readable.
+// The codec is registered if it is not yet.
+// It panics if another codec is registered under the id of codec, its records could not be read back.
+func (b *Bitcask) SetCompression(codec Codec, minSize int) {
+    if codec != nil {
+        codecsMu.Lock()
+        registered, isExist := codecs[codec.ID()]
+        if !isExist {
+            registerCodec(codec)
+        }
+        codecsMu.Unlock()
+        if isExist && registered != codec {
+            panic(fmt.Sprintf("bitcask: codec id %d registered by another codec", codec.ID()))
+        }
+    }
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.config.codec = codec
+    b.config.compressMinSize = minSize
+}
+
+// encodeValue compresses a value about to be written when compression is on.
+// returns the value to store and the record flags telling how it was stored.
+func (b *Bitcask) encodeValue(value string) (string, int, error) {
+    codec := b.config.codec
+    if codec == nil || len(value) < b.config.compressMinSize {
+        return value, 0, nil
+    }
+
+    compressed, err := codec.Compress([]byte(value))
+    if err != nil {
+        return "", 0, err
+    }
+    // Incompressible values are kept as they are.
+    if len(compressed) >= len(value) {
+        return value, 0, nil
+    }
+
+    return string(compressed), codec.ID() << codecShift, nil
+}
+
+// decodeValue returns the value of a data file line, decompressed if it was compressed.
+func decodeValue(dataRec dataRecord) (string, error) {
+    id := dataRec.flags >> codecShift & codecMask
+    if id == 0 {
+        return dataRec.value, nil
+    }
+
+    codecsMu.RLock()
+    codec, isExist := codecs[id]
+    codecsMu.RUnlock()
+    if !isExist {
+        return "", BitcaskError(fmt.Sprintf("%s: %d", UnknownCodec, id))
+    }
+
+    value, err := codec.Decompress([]byte(dataRec.value))
+    if err != nil {
+        return "", BitcaskError(CorruptedRecord)
+    }
+
+    return string(value), nil
+}
+
+// ID returns the id of the flate codec.
+func (flateCodec) ID() int {
+    return flateCodecId
+}
+
+// Compress compresses src with the default flate level.
+func (flateCodec) Compress(src []byte) ([]byte, error) {
+    var buf bytes.Buffer
+    writer, err := flate.NewWriter(&buf, flate.DefaultCompression)
+    if err != nil {
+        return nil, err
+    }
+    if _, err := writer.Write(src); err != nil {
+        return nil, err
+    }
+    if err := writer.Close(); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// Decompress expands src compressed by Compress.
+func (flateCodec) Decompress(src []byte) ([]byte, error) {
+    reader := flate.NewReader(bytes.NewReader(src))
+    defer reader.Close()
+    return io.ReadAll(reader)
+}
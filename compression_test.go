@@ -0,0 +1,104 @@
+package bitcask
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// halfCodec is a test codec that stores values made of two equal halves as one half.
+type halfCodec struct{}
+
+func (halfCodec) ID() int {
+    return 200
+}
+
+func (halfCodec) Compress(src []byte) ([]byte, error) {
+    return []byte(strings.TrimSuffix(string(src), string(src[len(src)/2:])) + "#"), nil
+}
+
+func (halfCodec) Decompress(src []byte) ([]byte, error) {
+    half := strings.TrimSuffix(string(src), "#")
+    return []byte(half + half), nil
+}
+
+// clashingCodec is a test codec that takes the id of FlateCodec.
+type clashingCodec struct{
+    halfCodec
+}
+
+func (clashingCodec) ID() int {
+    return flateCodecId
+}
+
+func TestCompression(t *testing.T) {
+    largeValue := strings.Repeat(`{"name": "bitcask", "type": "document"}`, 100)
+
+    t.Run("large values are compressed", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite, CompressValues)
+        b.Put("large", largeValue)
+        b.Put("small", "value")
+
+        if b.keyDir["large"].valueSize >= len(largeValue) {
+            t.Errorf("expected compressed size, got %d", b.keyDir["large"].valueSize)
+        }
+        if b.keyDir["small"].valueSize != len("value") {
+            t.Errorf("expected small value to be stored as it is, got size %d", b.keyDir["small"].valueSize)
+        }
+
+        got, _ := b.Get("large")
+        assertString(t, got, largeValue)
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("compressed values survive reopen and merge", func(t *testing.T) {
+        b1, _ := Open(testBitcaskPath, ReadWrite, CompressValues)
+        b1.Put("large", largeValue)
+        b1.Close()
+
+        b2, _ := Open(testBitcaskPath, ReadWrite)
+        b2.Merge()
+        compressedSize := b2.keyDir["large"].valueSize
+        got, _ := b2.Get("large")
+        b2.Close()
+
+        assertString(t, got, largeValue)
+        if compressedSize >= len(largeValue) {
+            t.Errorf("expected merge to keep the value compressed, got size %d", compressedSize)
+        }
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("custom codec", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.SetCompression(halfCodec{}, 4)
+        b.Put("key", "abcabc")
+
+        if b.keyDir["key"].valueSize != len("abc#") {
+            t.Errorf("expected value stored by the custom codec, got size %d", b.keyDir["key"].valueSize)
+        }
+        got, _ := b.Get("key")
+        assertString(t, got, "abcabc")
+
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("codec with the id of another codec", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        defer func() {
+            if recover() == nil {
+                t.Errorf("expected a codec with a taken id to be refused")
+            }
+            b.Close()
+            os.RemoveAll(testBitcaskPath)
+        }()
+        b.SetCompression(clashingCodec{}, 4)
+    })
+
+    t.Run("unknown codec", func(t *testing.T) {
+        _, err := decodeValue(dataRecord{value: "value", flags: 201 << codecShift})
+        assertError(t, err, "unknown codec: 201")
+    })
+}
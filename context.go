@@ -0,0 +1,68 @@
+package bitcask
+
+import (
+	"context"
+)
+
+// Progress reports how far a long running operation got, Done out of Total steps.
+// A merge counts the records it copies, opening a datastore counts the data files it loads.
+type Progress struct {
+    Done int
+    Total int
+}
+
+// OpenContext opens the bitcask datastore in dirPath like Open, loading the keydir stops once ctx is done.
+// progress, when not nil, is called after every data file loaded.
+// returns the error of ctx if it is done before the datastore is open, nothing is left locked then.
+func OpenContext(ctx context.Context, dirPath string, progress func(Progress), opts ...ConfigOpt) (*Bitcask, error) {
+    return openDatastore(ctx, OSFileSystem, dirPath, nil, opts, progress)
+}
+
+// OpenFSContext opens the bitcask datastore in dirPath of fsys like OpenFS and stops like OpenContext once ctx is done.
+// keyring encrypts the datastore like OpenEncrypted, nil when it is not encrypted.
+func OpenFSContext(ctx context.Context, fsys FileSystem, dirPath string, keyring *Keyring, progress func(Progress), opts ...ConfigOpt) (*Bitcask, error) {
+    return openDatastore(ctx, fsys, dirPath, keyring, opts, progress)
+}
+
+// MergeContext merges every sealed data file like Merge and stops once ctx is done.
+// A stopped merge removes the files it wrote and leaves the datastore as it was.
+// progress, when not nil, is called after every record copied.
+// returns an error if ReadWrite permission is not set or the error of ctx if it is done before the merge ends.
+func (b *Bitcask) MergeContext(ctx context.Context, progress func(Progress)) error {
+    if b.config.writePermission == ReadOnly {
+        return BitcaskError(WriteDenied)
+    }
+
+    b.merges.running.Lock()
+    defer b.merges.running.Unlock()
+
+    if err := b.sealResumedFile(); err != nil {
+        return err
+    }
+    usage, err := b.sealedFileUsage()
+    if err != nil {
+        return err
+    }
+
+    selected := make(map[string]bool)
+    for fileId := range usage {
+        selected[fileId] = true
+    }
+    return b.mergeFiles(ctx, selected, false, progress)
+}
+
+// FoldContext folds over the key/value pairs like Fold and stops once ctx is done.
+// returns the accumulator so far and the error of ctx if it is done before the fold ends.
+func (b *Bitcask) FoldContext(ctx context.Context, fun func(string, string, any) any, acc any) (any, error) {
+    for _, key := range b.ListKeys() {
+        if err := ctx.Err(); err != nil {
+            return acc, err
+        }
+        value, err := b.Get(key)
+        if err != nil {
+            continue
+        }
+        acc = fun(key, value, acc)
+    }
+    return acc, nil
+}
@@ -0,0 +1,210 @@
+package bitcask
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestMergeContext(t *testing.T) {
+    t.Run("progress reaches the total", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        fillActiveFile(b)
+        b.Put("key1", "value1")
+
+        var last Progress
+        calls := 0
+        err := b.MergeContext(context.Background(), func(p Progress) {
+            calls++
+            if p.Done != last.Done + 1 {
+                t.Errorf("got progress %d after %d", p.Done, last.Done)
+            }
+            last = p
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        if calls == 0 || last.Done != last.Total {
+            t.Errorf("got last progress %+v after %d calls, want done with the total", last, calls)
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("cancelled merge leaves the store as it was", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        fillActiveFile(b)
+        b.Put("key2", "value2")
+        before, _ := listDataFiles(testBitcaskPath)
+
+        ctx, cancel := context.WithCancel(context.Background())
+        err := b.MergeContext(ctx, func(p Progress) {
+            cancel()
+        })
+        if err != context.Canceled {
+            t.Fatalf("expected context canceled, got %v", err)
+        }
+        after, _ := listDataFiles(testBitcaskPath)
+        // The active file moved past the id of the merge file.
+        delete(after, b.activeFile.fileName)
+        if len(after) != len(before) {
+            t.Errorf("got %d data files after a cancelled merge, want %d", len(after), len(before))
+        }
+        for name := range before {
+            if !after[name] {
+                t.Errorf("expected data file %s to be kept", name)
+            }
+        }
+        got, _ := b.Get("key1")
+        assertString(t, got, "value1")
+        b.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        got, _ = b.Get("key1")
+        assertString(t, got, "value1")
+        got, _ = b.Get("key2")
+        assertString(t, got, "value2")
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("merge files get a data file name once synced", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        fillActiveFile(b)
+        b.Put("key2", "value2")
+        before, _ := listDataFiles(testBitcaskPath)
+
+        err := b.MergeContext(context.Background(), func(p Progress) {
+            during, _ := listDataFiles(testBitcaskPath)
+            for name := range during {
+                if !before[name] && name != b.activeFile.fileName {
+                    t.Errorf("expected unsealed merge file %s to have a temporary name", name)
+                }
+            }
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        b.Close()
+
+        // A merge file left by a process that stopped during a merge is removed on open.
+        leftover := path.Join(testBitcaskPath, mergeFilePrefix + "1")
+        os.WriteFile(leftover, []byte("partial"), fileMode)
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+            t.Errorf("expected the leftover merge file to be removed, got %v", err)
+        }
+        got, _ := b.Get("key1")
+        assertString(t, got, "value1")
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
+
+func TestOpenContext(t *testing.T) {
+    t.Run("progress counts the loaded files", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        fillActiveFile(b)
+        fillActiveFile(b)
+        b.Close()
+        dataFiles, _ := listDataFiles(testBitcaskPath)
+
+        var last Progress
+        b, err := OpenContext(context.Background(), testBitcaskPath, func(p Progress) {
+            last = p
+        }, ReadWrite)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if last.Total != len(dataFiles) || last.Done != last.Total {
+            t.Errorf("got last progress %+v, want %d files loaded", last, len(dataFiles))
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("cancelled open leaves no lock behind", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        fillActiveFile(b)
+        b.Close()
+
+        ctx, cancel := context.WithCancel(context.Background())
+        _, err := OpenContext(ctx, testBitcaskPath, func(p Progress) {
+            cancel()
+        }, ReadWrite)
+        if err != context.Canceled {
+            t.Fatalf("expected context canceled, got %v", err)
+        }
+
+        b, err = Open(testBitcaskPath, ReadWrite)
+        if err != nil {
+            t.Fatal(err)
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("encrypted datastore on a file system", func(t *testing.T) {
+        fsys := NewMemFileSystem()
+        keyring := testKeyring(t, 1)
+        b, _ := OpenFS(fsys, testBitcaskPath, keyring, ReadWrite, EncryptKeys)
+        b.Put("key1", "value1")
+        fillActiveFile(b)
+        b.Close()
+
+        ctx, cancel := context.WithCancel(context.Background())
+        _, err := OpenFSContext(ctx, fsys, testBitcaskPath, keyring, func(p Progress) {
+            cancel()
+        }, ReadWrite)
+        if err != context.Canceled {
+            t.Fatalf("expected context canceled, got %v", err)
+        }
+
+        loaded := 0
+        b, err = OpenFSContext(context.Background(), fsys, testBitcaskPath, keyring, func(p Progress) {
+            loaded = p.Done
+        }, ReadWrite)
+        if err != nil {
+            t.Fatal(err)
+        }
+        got, _ := b.Get("key1")
+        assertString(t, got, "value1")
+        if loaded == 0 {
+            t.Errorf("expected progress to be reported")
+        }
+        b.Close()
+
+        if _, err := os.Stat(testBitcaskPath); !os.IsNotExist(err) {
+            t.Errorf("expected nothing written to disk, got %v", err)
+        }
+    })
+}
+
+func TestFoldContext(t *testing.T) {
+    t.Run("cancelled fold returns the accumulator so far", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        for i := 0; i < 10; i++ {
+            b.Put(fmt.Sprintf("key%d", i), "value")
+        }
+
+        ctx, cancel := context.WithCancel(context.Background())
+        acc, err := b.FoldContext(ctx, func(key string, value string, acc any) any {
+            if acc.(int) == 2 {
+                cancel()
+            }
+            return acc.(int) + 1
+        }, 0)
+        if err != context.Canceled {
+            t.Fatalf("expected context canceled, got %v", err)
+        }
+        if acc.(int) != 3 {
+            t.Errorf("got %d pairs folded, want 3", acc)
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
@@ -0,0 +1,417 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// errCrashed is returned by every change made to a crashFS after it crashed.
+var errCrashed = errors.New("simulated crash")
+
+// crashFS is a FileSystem in memory that crashes after a given number of writes, syncs, renames and removes.
+// It remembers the synced content of every file, the names the directory had when it was last synced
+// and the renames and removes made since, so the state a crash leaves on disk can be rebuilt.
+// Creating a file is taken as durable once it returns, renaming and removing one only once its directory is synced.
+type crashFS struct {
+    FileSystem
+    mu sync.Mutex
+    ops int
+    crashAt int
+    crashed bool
+    // Files by their current name and by the name they have on disk.
+    current map[string]*crashEntry
+    durable map[string]*crashEntry
+    pending []crashDirOp
+}
+
+// crashDirOp is a rename, or a remove when newName is "", not yet made durable by a directory sync.
+type crashDirOp struct {
+    oldName string
+    newName string
+}
+
+// crashEntry is a file of a crashFS, it keeps its synced content across renames.
+type crashEntry struct {
+    synced []byte
+}
+
+// crashFile is a file opened on a crashFS.
+type crashFile struct {
+    File
+    fs *crashFS
+    entry *crashEntry
+}
+
+// newCrashFS returns a crashFS crashing after crashAt changes, never when crashAt is 0.
+func newCrashFS(crashAt int) *crashFS {
+    return &crashFS{
+        FileSystem: NewMemFileSystem(),
+        crashAt:    crashAt,
+        current:    make(map[string]*crashEntry),
+        durable:    make(map[string]*crashEntry),
+    }
+}
+
+// step counts a write, sync, rename or remove, which still happens when the crash comes right after it.
+func (c *crashFS) step() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.crashed {
+        return errCrashed
+    }
+    c.ops++
+    c.crashed = c.ops == c.crashAt
+    return nil
+}
+
+// isCrashed reports whether the crash happened.
+func (c *crashFS) isCrashed() bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    return c.crashed
+}
+
+func (c *crashFS) OpenFile(name string, flag int) (File, error) {
+    if flag & (os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC) != 0 && c.isCrashed() {
+        return nil, errCrashed
+    }
+    file, err := c.FileSystem.OpenFile(name, flag)
+    if err != nil {
+        return nil, err
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    name = path.Clean(name)
+    entry, isExist := c.current[name]
+    if !isExist {
+        entry = &crashEntry{synced: []byte{}}
+        c.current[name] = entry
+        c.durable[name] = entry
+    }
+    return &crashFile{File: file, fs: c, entry: entry}, nil
+}
+
+func (c *crashFS) Rename(oldName string, newName string) error {
+    if err := c.step(); err != nil {
+        return err
+    }
+    if err := c.FileSystem.Rename(oldName, newName); err != nil {
+        return err
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    oldName, newName = path.Clean(oldName), path.Clean(newName)
+    c.current[newName] = c.current[oldName]
+    delete(c.current, oldName)
+    c.pending = append(c.pending, crashDirOp{oldName: oldName, newName: newName})
+    return nil
+}
+
+func (c *crashFS) Remove(name string) error {
+    if err := c.step(); err != nil {
+        return err
+    }
+    if err := c.FileSystem.Remove(name); err != nil {
+        return err
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    delete(c.current, path.Clean(name))
+    c.pending = append(c.pending, crashDirOp{oldName: path.Clean(name)})
+    return nil
+}
+
+// SyncDir makes the renames and removes done in the directory so far durable.
+func (c *crashFS) SyncDir(name string) error {
+    if err := c.step(); err != nil {
+        return err
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    var others []crashDirOp
+    for _, op := range c.pending {
+        if path.Dir(op.oldName) == path.Clean(name) {
+            op.apply(c.durable)
+        } else {
+            others = append(others, op)
+        }
+    }
+    c.pending = others
+    return nil
+}
+
+// apply makes the rename or remove in the files by name.
+func (op crashDirOp) apply(files map[string]*crashEntry) {
+    entry, isExist := files[op.oldName]
+    if !isExist {
+        return
+    }
+    delete(files, op.oldName)
+    if op.newName != "" {
+        files[op.newName] = entry
+    }
+}
+
+func (c *crashFS) MkdirAll(name string) error {
+    if c.isCrashed() {
+        return errCrashed
+    }
+    return c.FileSystem.MkdirAll(name)
+}
+
+func (c *crashFS) Lock(name string) error {
+    file, err := c.OpenFile(name, os.O_CREATE | os.O_EXCL | os.O_WRONLY)
+    if err != nil {
+        return err
+    }
+    return file.Close()
+}
+
+// afterCrash returns the files a crash leaves on disk under their durable names: the synced content of every file,
+// followed by the first half of its unsynced content when torn is set. Lock files are left out, the process that took them crashed.
+// When reached is set the renames and removes not yet synced reached the disk too, in the order they were made.
+func (c *crashFS) afterCrash(t *testing.T, torn bool, reached bool) FileSystem {
+    t.Helper()
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    files := make(map[string]*crashEntry)
+    for name, entry := range c.durable {
+        files[name] = entry
+    }
+    if reached {
+        for _, op := range c.pending {
+            op.apply(files)
+        }
+    }
+
+    recovered := NewMemFileSystem()
+    for name, entry := range files {
+        base := path.Base(name)
+        if strings.HasPrefix(base, readLock) || strings.HasPrefix(base, writeLock) {
+            continue
+        }
+
+        content := entry.synced
+        // Only a file still under the same name can be read for its unsynced content.
+        if c.current[name] == entry && torn {
+            if current, _ := readFile(c.FileSystem, name); len(current) > len(content) {
+                content = current[:len(content) + (len(current) - len(content)) / 2]
+            }
+        }
+        recovered.MkdirAll(path.Dir(name))
+        if err := writeSyncedFile(recovered, name, string(content)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    recovered.MkdirAll(testBitcaskPath)
+
+    return recovered
+}
+
+func (f *crashFile) Write(p []byte) (int, error) {
+    if err := f.fs.step(); err != nil {
+        return 0, err
+    }
+    return f.File.Write(p)
+}
+
+func (f *crashFile) WriteAt(p []byte, off int64) (int, error) {
+    if err := f.fs.step(); err != nil {
+        return 0, err
+    }
+    return f.File.WriteAt(p, off)
+}
+
+func (f *crashFile) Truncate(size int64) error {
+    if err := f.fs.step(); err != nil {
+        return err
+    }
+    return f.File.Truncate(size)
+}
+
+func (f *crashFile) Sync() error {
+    if err := f.fs.step(); err != nil {
+        return err
+    }
+    if err := f.File.Sync(); err != nil {
+        return err
+    }
+
+    info, err := f.File.Stat()
+    if err != nil {
+        return err
+    }
+    content := make([]byte, info.Size())
+    if _, err := f.File.ReadAt(content, 0); err != nil && info.Size() > 0 {
+        return err
+    }
+
+    f.fs.mu.Lock()
+    defer f.fs.mu.Unlock()
+    f.entry.synced = content
+    return nil
+}
+
+// crashModel tracks the values a datastore may hold after a crash.
+// A key holds its durable value or one of the values written after the last sync, "" stands for a deleted key.
+type crashModel struct {
+    durable map[string]string
+    pending map[string][]string
+}
+
+// write records a put, or a delete when value is "", before it is applied.
+func (m *crashModel) write(key string, value string) {
+    m.pending[key] = append(m.pending[key], value)
+}
+
+// synced makes all writes so far durable.
+func (m *crashModel) synced() {
+    for key, values := range m.pending {
+        m.durable[key] = values[len(values) - 1]
+    }
+    m.pending = make(map[string][]string)
+}
+
+// check fails if the datastore holds a value the model does not allow or a value cannot be read.
+func (m *crashModel) check(t *testing.T, b *Bitcask, name string) {
+    t.Helper()
+
+    for _, key := range b.ListKeys() {
+        if _, isExist := m.durable[key]; !isExist && len(m.pending[key]) == 0 {
+            t.Errorf("%s: unknown key %s", name, key)
+        }
+    }
+
+    keys := make(map[string]bool)
+    for key := range m.durable {
+        keys[key] = true
+    }
+    for key := range m.pending {
+        keys[key] = true
+    }
+
+    for key := range keys {
+        got, err := b.Get(key)
+        if err != nil && err.Error() != key + ": " + KeyDoesNotExist {
+            t.Errorf("%s: %s: %v", name, key, err)
+            continue
+        }
+
+        allowed := append([]string{m.durable[key]}, m.pending[key]...)
+        found := false
+        for _, value := range allowed {
+            found = found || value == got
+        }
+        if !found {
+            t.Errorf("%s: %s holds %.20q, want the synced value %.20q or a later one", name, key, got, m.durable[key])
+        }
+    }
+}
+
+// runCrashWorkload puts, deletes, syncs, rotates, merges and closes a datastore on fsys until fsys crashes.
+// returns the model of the writes made, a write interrupted by the crash counts as not synced.
+func runCrashWorkload(t *testing.T, fsys *crashFS, opts []ConfigOpt) *crashModel {
+    t.Helper()
+
+    model := &crashModel{durable: make(map[string]string), pending: make(map[string][]string)}
+    syncOnPut := len(opts) > 1 && opts[1] == SyncOnPut
+
+    b, err := OpenFS(fsys, testBitcaskPath, nil, opts...)
+    if err != nil {
+        if !fsys.isCrashed() {
+            t.Fatal(err)
+        }
+        return model
+    }
+
+    // done applies the outcome of a step, false once the crash happened.
+    done := func(err error, synced bool) bool {
+        if fsys.isCrashed() {
+            return false
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        if synced {
+            model.synced()
+        }
+        return true
+    }
+
+    for i := 0; i < 60; i++ {
+        key := fmt.Sprintf("key%d", i % 40)
+        value := fmt.Sprintf("value%d-%s", i, strings.Repeat("x", 400))
+
+        switch {
+        case i == 50:
+            if !done(b.Merge(), true) {
+                return model
+            }
+        case i % 11 == 10 && i > 20:
+            // The key written twenty steps before is deleted, its record is in an older file than the tombstone.
+            key = fmt.Sprintf("key%d", i - 20)
+            model.write(key, "")
+            if !done(b.Delete(key), syncOnPut) {
+                return model
+            }
+            continue
+        case i % 7 == 6:
+            if !done(b.Sync(), true) {
+                return model
+            }
+        }
+
+        model.write(key, value)
+        if i % 5 == 4 {
+            err = b.PutReader(key, strings.NewReader(value), int64(len(value)))
+        } else {
+            err = b.Put(key, value)
+        }
+        if !done(err, syncOnPut) {
+            return model
+        }
+    }
+
+    b.Close()
+    done(nil, true)
+    return model
+}
+
+func TestCrashConsistency(t *testing.T) {
+    for _, opts := range [][]ConfigOpt{{ReadWrite}, {ReadWrite, SyncOnPut}} {
+        fsys := newCrashFS(0)
+        runCrashWorkload(t, fsys, opts)
+        total := fsys.ops
+
+        for crashAt := 1; crashAt <= total; crashAt++ {
+            fsys := newCrashFS(crashAt)
+            model := runCrashWorkload(t, fsys, opts)
+
+            for _, torn := range []bool{false, true} {
+                for _, reached := range []bool{false, true} {
+                    name := fmt.Sprintf("options %v, crash after operation %d of %d, torn %v, unsynced renames and removes %v",
+                    opts, crashAt, total, torn, reached)
+                    b, err := OpenFS(fsys.afterCrash(t, torn, reached), testBitcaskPath, nil, ReadWrite)
+                    if err != nil {
+                        t.Fatalf("%s: %v", name, err)
+                    }
+                    model.check(t, b, name)
+                    b.Close()
+                }
+            }
+        }
+    }
+}
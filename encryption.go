@@ -0,0 +1,257 @@
+package bitcask
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+const (
+    // EncryptKeys makes an encrypted bitcask encrypt keys too,
+    // so data, hint and keydir files do not hold them in plain text.
+    EncryptKeys ConfigOpt = 5
+
+    // Error message when a record is encrypted with a key missing from the keyring.
+    MissingEncryptionKey = "missing encryption key"
+    // Error message when a record cannot be decrypted with its key.
+    CannotDecrypt = "cannot decrypt record"
+    // Error message when a key added to a keyring has a bad id or length.
+    InvalidEncryptionKey = "invalid encryption key"
+)
+
+const (
+    // Flag of records whose key is encrypted.
+    keyEncryptedFlag = 2
+
+    // Position and mask of the encryption key id in the record flags.
+    encryptionKeyShift = 16
+    encryptionKeyMask = 0xffff
+
+    // Size of the key id written in front of encrypted keys.
+    keyIdSize = 2
+)
+
+// Keyring holds the AES keys a bitcask datastore is encrypted with.
+// Every key has an id written in the header of the records it encrypts,
+// so old keys must stay in the keyring until a merge re-encrypted their records.
+// It is safe to use from multiple goroutines.
+type Keyring struct {
+    mu sync.RWMutex
+    keys map[int]cipher.AEAD
+    current int
+}
+
+// NewKeyring creates an empty keyring.
+func NewKeyring() *Keyring {
+    return &Keyring{keys: make(map[int]cipher.AEAD)}
+}
+
+// Add adds an AES-128, AES-192 or AES-256 key under an id from 1 to 65535.
+// The first key added becomes the current key.
+// returns an error if the id is out of range or taken or the key has a bad length.
+func (k *Keyring) Add(id int, key []byte) error {
+    if id < 1 || id > encryptionKeyMask {
+        return BitcaskError(fmt.Sprintf("%s: id %d out of range", InvalidEncryptionKey, id))
+    }
+
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return BitcaskError(fmt.Sprintf("%s: %s", InvalidEncryptionKey, err))
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return BitcaskError(fmt.Sprintf("%s: %s", InvalidEncryptionKey, err))
+    }
+
+    k.mu.Lock()
+    defer k.mu.Unlock()
+
+    if _, isExist := k.keys[id]; isExist {
+        return BitcaskError(fmt.Sprintf("%s: id %d added twice", InvalidEncryptionKey, id))
+    }
+    k.keys[id] = aead
+    if k.current == 0 {
+        k.current = id
+    }
+
+    return nil
+}
+
+// SetCurrent makes later writes and merges encrypt with the key of the given id.
+// returns an error if the keyring has no key with this id.
+func (k *Keyring) SetCurrent(id int) error {
+    k.mu.Lock()
+    defer k.mu.Unlock()
+
+    if _, isExist := k.keys[id]; !isExist {
+        return BitcaskError(fmt.Sprintf("%s: %d", MissingEncryptionKey, id))
+    }
+    k.current = id
+
+    return nil
+}
+
+// currentKey returns the id and cipher of the current key, id 0 if the keyring is empty.
+func (k *Keyring) currentKey() (int, cipher.AEAD) {
+    k.mu.RLock()
+    defer k.mu.RUnlock()
+
+    return k.current, k.keys[k.current]
+}
+
+// key returns the cipher of the key with the given id.
+func (k *Keyring) key(id int) (cipher.AEAD, error) {
+    if k == nil {
+        return nil, BitcaskError(fmt.Sprintf("%s: %d", MissingEncryptionKey, id))
+    }
+
+    k.mu.RLock()
+    defer k.mu.RUnlock()
+
+    aead, isExist := k.keys[id]
+    if !isExist {
+        return nil, BitcaskError(fmt.Sprintf("%s: %d", MissingEncryptionKey, id))
+    }
+
+    return aead, nil
+}
+
+// OpenEncrypted opens a bitcask datastore like Open, encrypting values with the current key of keyring.
+// Keys are encrypted too when EncryptKeys is given.
+// Records written without encryption stay readable and are encrypted by the next merge.
+func OpenEncrypted(dirPath string, keyring *Keyring, opts ...ConfigOpt) (*Bitcask, error) {
+    return openDatastore(context.Background(), OSFileSystem, dirPath, keyring, opts, nil)
+}
+
+// sealRecord encrypts the key and the stored value of a record about to be written when encryption is on.
+// returns the key and value to store and the record flags telling how they were stored.
+// Tombstones are never encrypted so they are recognized without the keyring.
+func (b *Bitcask) sealRecord(key string, value string, flags int) (string, string, int, error) {
+    keyring := b.config.keyring
+    if keyring == nil {
+        return key, value, flags, nil
+    }
+    id, aead := keyring.currentKey()
+    if id == 0 {
+        return key, value, flags, nil
+    }
+
+    storedKey := key
+    if b.config.encryptKeys {
+        var err error
+        storedKey, err = sealKey(id, aead, key)
+        if err != nil {
+            return "", "", 0, err
+        }
+        flags |= keyEncryptedFlag
+    }
+
+    if flags & tombstoneFlag != 0 {
+        return storedKey, value, flags, nil
+    }
+
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return "", "", 0, err
+    }
+    // The stored key is authenticated with the value so a value cannot be moved to another key.
+    sealed := aead.Seal(nonce, nonce, []byte(value), []byte(storedKey))
+
+    return storedKey, string(sealed), flags | id << encryptionKeyShift, nil
+}
+
+// openRecord decrypts the key and value of a data file line.
+// The returned record keeps the codec flags, its value still has to go through decodeValue.
+func openRecord(keyring *Keyring, dataRec dataRecord) (dataRecord, error) {
+    key, err := decodeKey(keyring, dataRec.key, dataRec.flags)
+    if err != nil {
+        return dataRecord{}, err
+    }
+
+    if id := dataRec.flags >> encryptionKeyShift & encryptionKeyMask; id != 0 {
+        aead, err := keyring.key(id)
+        if err != nil {
+            return dataRecord{}, err
+        }
+        sealed := []byte(dataRec.value)
+        if len(sealed) < aead.NonceSize() {
+            return dataRecord{}, BitcaskError(CannotDecrypt)
+        }
+        nonce := sealed[:aead.NonceSize()]
+        value, err := aead.Open(nil, nonce, sealed[aead.NonceSize():], []byte(dataRec.key))
+        if err != nil {
+            return dataRecord{}, BitcaskError(CannotDecrypt)
+        }
+        dataRec.value = string(value)
+    }
+
+    dataRec.key = key
+    dataRec.flags &^= keyEncryptedFlag | encryptionKeyMask << encryptionKeyShift
+
+    return dataRec, nil
+}
+
+// encodeKey returns a key as it is written in keydir files and its flags.
+// Keys are encrypted whenever there is a keyring, since a reader cannot tell whether the writer encrypts them.
+func (b *Bitcask) encodeKey(key string) (string, int) {
+    if b.config.keyring == nil {
+        return key, 0
+    }
+    id, aead := b.config.keyring.currentKey()
+    if id == 0 {
+        return key, 0
+    }
+
+    storedKey, err := sealKey(id, aead, key)
+    if err != nil {
+        return key, 0
+    }
+
+    return storedKey, keyEncryptedFlag
+}
+
+// sealKey encrypts a key into hex text holding the key id, the nonce and the sealed key,
+// so it can be written in line based hint and keydir files.
+func sealKey(id int, aead cipher.AEAD, key string) (string, error) {
+    blob := make([]byte, keyIdSize + aead.NonceSize())
+    binary.BigEndian.PutUint16(blob, uint16(id))
+    if _, err := rand.Read(blob[keyIdSize:]); err != nil {
+        return "", err
+    }
+    blob = aead.Seal(blob, blob[keyIdSize:], []byte(key), nil)
+
+    return hex.EncodeToString(blob), nil
+}
+
+// decodeKey returns the plain key of a stored key, decrypting it if flags say it is encrypted.
+func decodeKey(keyring *Keyring, storedKey string, flags int) (string, error) {
+    if flags & keyEncryptedFlag == 0 {
+        return storedKey, nil
+    }
+
+    blob, err := hex.DecodeString(storedKey)
+    if err != nil || len(blob) < keyIdSize {
+        return "", BitcaskError(CannotDecrypt)
+    }
+
+    aead, err := keyring.key(int(binary.BigEndian.Uint16(blob)))
+    if err != nil {
+        return "", err
+    }
+    if len(blob) < keyIdSize + aead.NonceSize() {
+        return "", BitcaskError(CannotDecrypt)
+    }
+
+    nonce := blob[keyIdSize:keyIdSize + aead.NonceSize()]
+    key, err := aead.Open(nil, nonce, blob[keyIdSize + aead.NonceSize():], nil)
+    if err != nil {
+        return "", BitcaskError(CannotDecrypt)
+    }
+
+    return string(key), nil
+}
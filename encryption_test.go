@@ -0,0 +1,203 @@
+package bitcask
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// testKeyring returns a keyring with one AES-256 key per given id.
+func testKeyring(t *testing.T, ids ...int) *Keyring {
+    t.Helper()
+
+    keyring := NewKeyring()
+    for _, id := range ids {
+        if err := keyring.Add(id, bytes.Repeat([]byte{byte(id)}, 32)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return keyring
+}
+
+// assertNoPlainText fails if any datastore file holds one of the given strings.
+func assertNoPlainText(t *testing.T, dirPath string, texts ...string) {
+    t.Helper()
+
+    files, _ := os.ReadDir(dirPath)
+    for _, file := range files {
+        data, _ := os.ReadFile(path.Join(dirPath, file.Name()))
+        for _, text := range texts {
+            if strings.Contains(string(data), text) {
+                t.Errorf("%s holds %q in plain text", file.Name(), text)
+            }
+        }
+    }
+}
+
+func TestEncryption(t *testing.T) {
+    t.Run("values are encrypted and survive reopen", func(t *testing.T) {
+        b, err := OpenEncrypted(testBitcaskPath, testKeyring(t, 1), ReadWrite)
+        if err != nil {
+            t.Fatal(err)
+        }
+        b.Put("key1", "secret value")
+        b.Put("key2", "other secret")
+        b.Delete("key2")
+        b.Close()
+
+        assertNoPlainText(t, testBitcaskPath, "secret value", "other secret")
+
+        b, _ = OpenEncrypted(testBitcaskPath, testKeyring(t, 1), ReadWrite)
+        got, _ := b.Get("key1")
+        assertString(t, got, "secret value")
+        if _, err := b.Get("key2"); err == nil {
+            t.Errorf("expected deleted key to stay deleted")
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("keys are not written in plain text", func(t *testing.T) {
+        b, _ := OpenEncrypted(testBitcaskPath, testKeyring(t, 1), ReadWrite, EncryptKeys)
+        b.Put("user:alice", "value1")
+        b.Put("user:bob", "value2")
+        b.Merge()
+        b.Put("user:carol", "value3")
+        b.Close()
+
+        r, err := OpenEncrypted(testBitcaskPath, testKeyring(t, 1), ReadOnly)
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertNoPlainText(t, testBitcaskPath, "user:", "value1", "value3")
+
+        r2, err := OpenEncrypted(testBitcaskPath, testKeyring(t, 1), ReadOnly)
+        if err != nil {
+            t.Fatal(err)
+        }
+        got, _ := r2.Get("user:alice")
+        assertString(t, got, "value1")
+        if len(r2.ListKeys()) != 3 {
+            t.Errorf("expected 3 keys, got %v", r2.ListKeys())
+        }
+        r2.Close()
+        r.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("merge encrypts records with the current key", func(t *testing.T) {
+        keyring := testKeyring(t, 1, 2)
+        b, _ := OpenEncrypted(testBitcaskPath, keyring, ReadWrite, EncryptKeys)
+        b.Put("key1", "value1")
+        b.Put("key2", "value2")
+
+        keyring.SetCurrent(2)
+        b.Put("key3", "value3")
+        got, _ := b.Get("key1")
+        assertString(t, got, "value1")
+        b.Close()
+
+        b, _ = OpenEncrypted(testBitcaskPath, keyring, ReadWrite, EncryptKeys)
+        b.Merge()
+        b.Close()
+
+        b, err := OpenEncrypted(testBitcaskPath, testKeyring(t, 2), ReadWrite)
+        if err != nil {
+            t.Fatalf("expected old key to be retired by merge, got %v", err)
+        }
+        for _, key := range []string{"key1", "key2", "key3"} {
+            got, err := b.Get(key)
+            if err != nil {
+                t.Fatal(err)
+            }
+            assertString(t, got, "value" + strings.TrimPrefix(key, "key"))
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("plain records are encrypted by merge", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "plain value")
+        b.Close()
+
+        b, _ = OpenEncrypted(testBitcaskPath, testKeyring(t, 1), ReadWrite)
+        got, _ := b.Get("key1")
+        assertString(t, got, "plain value")
+        b.Close()
+
+        b, _ = OpenEncrypted(testBitcaskPath, testKeyring(t, 1), ReadWrite)
+        b.Merge()
+        b.Close()
+
+        assertNoPlainText(t, testBitcaskPath, "plain value")
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("missing key fails", func(t *testing.T) {
+        b, _ := OpenEncrypted(testBitcaskPath, testKeyring(t, 1), ReadWrite)
+        b.Put("key1", "value1")
+        b.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        _, err := b.Get("key1")
+        assertError(t, err, "key1: " + MissingEncryptionKey + ": 1")
+        b.Close()
+
+        b, _ = OpenEncrypted(testBitcaskPath, testKeyring(t, 1), ReadWrite, EncryptKeys)
+        b.Put("key2", "value2")
+        b.Close()
+
+        if _, err := OpenEncrypted(testBitcaskPath, testKeyring(t, 3), ReadWrite); err == nil {
+            t.Errorf("expected open to fail without the key of encrypted keys")
+        }
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("wrong key fails", func(t *testing.T) {
+        b, _ := OpenEncrypted(testBitcaskPath, testKeyring(t, 1), ReadWrite)
+        b.Put("key1", "value1")
+        b.Close()
+
+        keyring := NewKeyring()
+        keyring.Add(1, bytes.Repeat([]byte{9}, 32))
+        b, _ = OpenEncrypted(testBitcaskPath, keyring, ReadWrite)
+        _, err := b.Get("key1")
+        assertError(t, err, "key1: " + CannotDecrypt)
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("invalid keys are refused", func(t *testing.T) {
+        keyring := NewKeyring()
+        if err := keyring.Add(1, []byte("short")); err == nil {
+            t.Errorf("expected error for bad key length")
+        }
+        if err := keyring.Add(0, bytes.Repeat([]byte{1}, 16)); err == nil {
+            t.Errorf("expected error for id 0")
+        }
+        if err := keyring.SetCurrent(7); err == nil {
+            t.Errorf("expected error for unknown id")
+        }
+    })
+
+    t.Run("tail decrypts with its keyring", func(t *testing.T) {
+        keyring := testKeyring(t, 1)
+        b, _ := OpenEncrypted(testBitcaskPath, keyring, ReadWrite, EncryptKeys)
+        b.Put("key1", "value1")
+        b.Sync()
+
+        tail := OpenTail(testBitcaskPath, "key", "", 0)
+        tail.SetKeyring(keyring)
+        events, err := tail.Next()
+        if err != nil || len(events) != 1 {
+            t.Fatalf("expected one event, got %v %v", events, err)
+        }
+        assertString(t, events[0].Key, "key1")
+        assertString(t, events[0].Value, "value1")
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
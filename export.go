@@ -0,0 +1,257 @@
+package bitcask
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+const (
+    // Error message when an import stream is not in a known export format.
+    UnknownExportFormat = "unknown export format"
+    // Error message when an import stream is damaged or ends early.
+    CorruptedExport = "corrupted export stream"
+)
+
+const (
+    // Magic bytes starting a binary export stream followed by its version.
+    exportMagic = "BCEX"
+    exportVersion = 1
+    // Name of the format in the header of a JSON Lines export stream.
+    exportJSONFormat = "bitcask-export"
+
+    // Kinds of the entries in a binary export stream.
+    exportRecordKind = 'R'
+    exportEndKind = 'E'
+)
+
+// exportHeader is the first line of a JSON Lines export stream.
+type exportHeader struct {
+    Format string `json:"format"`
+    Version int `json:"version"`
+}
+
+// exportRecord is a key/value line of a JSON Lines export stream.
+type exportRecord struct {
+    Key string `json:"key"`
+    Value string `json:"value"`
+    Tstamp int `json:"tstamp"`
+}
+
+// Export writes all key/value pairs with their timestamps to w in a binary stream.
+// Every record is length prefixed and checksummed and the stream ends with the record count,
+// so Import can detect a damaged or cut stream.
+// The pairs are those of a snapshot taken first, the lock is not held while w is written.
+func (b *Bitcask) Export(w io.Writer) error {
+    s, keys := b.exportSnapshot()
+    defer s.Release()
+
+    writer := bufio.NewWriter(w)
+    writer.WriteString(exportMagic)
+    writer.WriteByte(exportVersion)
+
+    count := 0
+    for _, key := range keys {
+        value, err := s.Get(key)
+        if err != nil {
+            return err
+        }
+
+        entry := make([]byte, 1 + 8 + 4 + 4 + len(key) + len(value) + 4)
+        entry[0] = exportRecordKind
+        binary.BigEndian.PutUint64(entry[1:9], uint64(s.keyDir[key].tstamp))
+        binary.BigEndian.PutUint32(entry[9:13], uint32(len(key)))
+        binary.BigEndian.PutUint32(entry[13:17], uint32(len(value)))
+        copy(entry[17:], key)
+        copy(entry[17 + len(key):], value)
+        crcPos := len(entry) - 4
+        binary.BigEndian.PutUint32(entry[crcPos:], crc32.ChecksumIEEE(entry[:crcPos]))
+
+        if _, err := writer.Write(entry); err != nil {
+            return err
+        }
+        count++
+    }
+
+    trailer := make([]byte, 1 + 8 + 4)
+    trailer[0] = exportEndKind
+    binary.BigEndian.PutUint64(trailer[1:9], uint64(count))
+    binary.BigEndian.PutUint32(trailer[9:], crc32.ChecksumIEEE(trailer[:9]))
+    writer.Write(trailer)
+
+    return writer.Flush()
+}
+
+// ExportJSON writes all key/value pairs with their timestamps to w as JSON Lines.
+// The format is meant to be read by humans, values that are not valid UTF-8 are not kept exactly.
+// The pairs are those of a snapshot taken first, the lock is not held while w is written.
+func (b *Bitcask) ExportJSON(w io.Writer) error {
+    s, keys := b.exportSnapshot()
+    defer s.Release()
+
+    writer := bufio.NewWriter(w)
+    encoder := json.NewEncoder(writer)
+
+    if err := encoder.Encode(exportHeader{Format: exportJSONFormat, Version: exportVersion}); err != nil {
+        return err
+    }
+
+    for _, key := range keys {
+        value, err := s.Get(key)
+        if err != nil {
+            return err
+        }
+        if err := encoder.Encode(exportRecord{Key: key, Value: value, Tstamp: s.keyDir[key].tstamp}); err != nil {
+            return err
+        }
+    }
+
+    return writer.Flush()
+}
+
+// exportSnapshot takes the snapshot an export reads from and returns it with its keys sorted.
+// The caller releases the snapshot.
+func (b *Bitcask) exportSnapshot() (*Snapshot, []string) {
+    s := b.Snapshot()
+    keys := s.ListKeys()
+    sort.Strings(keys)
+    return s, keys
+}
+
+// Import reads a stream written by Export or ExportJSON and stores its key/value pairs
+// keeping their original timestamps.
+// The lock is only taken to store each pair, so reading a slow stream does not block other calls.
+// Records read before a damaged part of the stream or a value over the maximum value size are kept.
+// returns an error if ReadWrite permission is not set.
+func (b *Bitcask) Import(r io.Reader) error {
+    if b.config.writePermission == ReadOnly {
+        return BitcaskError(WriteDenied)
+    }
+
+    reader := bufio.NewReader(r)
+    start, err := reader.Peek(1)
+    if err != nil {
+        return BitcaskError(UnknownExportFormat)
+    }
+
+    if start[0] == '{' {
+        return b.importJSON(reader)
+    }
+    return b.importBinary(reader)
+}
+
+// importBinary stores the records of a binary export stream.
+func (b *Bitcask) importBinary(reader *bufio.Reader) error {
+    header := make([]byte, len(exportMagic) + 1)
+    if _, err := io.ReadFull(reader, header); err != nil || string(header[:len(exportMagic)]) != exportMagic {
+        return BitcaskError(UnknownExportFormat)
+    }
+    if header[len(exportMagic)] != exportVersion {
+        return BitcaskError(fmt.Sprintf("%s: version %d", UnknownExportFormat, header[len(exportMagic)]))
+    }
+
+    count := 0
+    for {
+        kind, err := reader.ReadByte()
+        if err != nil {
+            return BitcaskError(CorruptedExport)
+        }
+
+        switch kind {
+        case exportRecordKind:
+            entry := make([]byte, 1 + 8 + 4 + 4)
+            entry[0] = kind
+            if _, err := io.ReadFull(reader, entry[1:]); err != nil {
+                return BitcaskError(CorruptedExport)
+            }
+
+            tstamp := int(binary.BigEndian.Uint64(entry[1:9]))
+            keySize := int(binary.BigEndian.Uint32(entry[9:13]))
+            valueSize := int(binary.BigEndian.Uint32(entry[13:17]))
+
+            // Copy instead of allocating the declared size so a damaged size cannot exhaust memory.
+            var buf bytes.Buffer
+            if _, err := io.CopyN(&buf, reader, int64(keySize)); err != nil {
+                return BitcaskError(CorruptedExport)
+            }
+            // A value over the maximum value size is refused before it is read.
+            b.mu.RLock()
+            err = b.checkValueSize(buf.String(), valueSize)
+            b.mu.RUnlock()
+            if err != nil {
+                return err
+            }
+            if _, err := io.CopyN(&buf, reader, int64(valueSize + 4)); err != nil {
+                return BitcaskError(CorruptedExport)
+            }
+            data := buf.Bytes()
+            entry = append(entry, data[:keySize + valueSize]...)
+            if binary.BigEndian.Uint32(data[keySize + valueSize:]) != crc32.ChecksumIEEE(entry) {
+                return BitcaskError(CorruptedExport)
+            }
+
+            if err := b.importRecord(string(data[:keySize]), string(data[keySize:keySize + valueSize]), tstamp); err != nil {
+                return err
+            }
+            count++
+        case exportEndKind:
+            trailer := make([]byte, 1 + 8 + 4)
+            trailer[0] = kind
+            if _, err := io.ReadFull(reader, trailer[1:]); err != nil {
+                return BitcaskError(CorruptedExport)
+            }
+            if binary.BigEndian.Uint32(trailer[9:]) != crc32.ChecksumIEEE(trailer[:9]) ||
+            int(binary.BigEndian.Uint64(trailer[1:9])) != count {
+                return BitcaskError(CorruptedExport)
+            }
+            return nil
+        default:
+            return BitcaskError(CorruptedExport)
+        }
+    }
+}
+
+// importJSON stores the records of a JSON Lines export stream.
+func (b *Bitcask) importJSON(reader *bufio.Reader) error {
+    decoder := json.NewDecoder(reader)
+
+    var header exportHeader
+    if err := decoder.Decode(&header); err != nil || header.Format != exportJSONFormat {
+        return BitcaskError(UnknownExportFormat)
+    }
+    if header.Version != exportVersion {
+        return BitcaskError(fmt.Sprintf("%s: version %d", UnknownExportFormat, header.Version))
+    }
+
+    for {
+        var rec exportRecord
+        err := decoder.Decode(&rec)
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return BitcaskError(CorruptedExport)
+        }
+
+        if err := b.importRecord(rec.Key, rec.Value, rec.Tstamp); err != nil {
+            return err
+        }
+    }
+}
+
+// importRecord stores an imported key/value pair with its timestamp, holding the lock only for the write.
+// returns an error if the value is longer than the maximum value size.
+func (b *Bitcask) importRecord(key string, value string, tstamp int) error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if err := b.checkValueSize(key, len(value)); err != nil {
+        return err
+    }
+    return b.put(key, value, tstamp)
+}
@@ -0,0 +1,199 @@
+package bitcask
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"testing"
+)
+
+var testImportPath = path.Join("testing_import_dir")
+
+func TestExport(t *testing.T) {
+    t.Run("binary round trip keeps values and timestamps", func(t *testing.T) {
+        b1, _ := Open(testBitcaskPath, ReadWrite)
+        for i := 0; i < 100; i++ {
+            b1.Put(fmt.Sprintf("key%d", i + 1), fmt.Sprintf("value\n%d", i + 1))
+        }
+
+        var stream bytes.Buffer
+        err := b1.Export(&stream)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        b2, _ := Open(testImportPath, ReadWrite)
+        err = b2.Import(&stream)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        got, _ := b2.Get("key50")
+        assertString(t, got, "value\n50")
+        if b1.keyDir["key50"].tstamp != b2.keyDir["key50"].tstamp {
+            t.Errorf("got tstamp %d, want %d", b2.keyDir["key50"].tstamp, b1.keyDir["key50"].tstamp)
+        }
+
+        b1.Close()
+        b2.Close()
+        os.RemoveAll(testBitcaskPath)
+        os.RemoveAll(testImportPath)
+    })
+
+    t.Run("json lines round trip", func(t *testing.T) {
+        b1, _ := Open(testBitcaskPath, ReadWrite)
+        b1.Put("key1", "value1")
+        b1.Put("key2", "value2")
+
+        var stream bytes.Buffer
+        b1.ExportJSON(&stream)
+
+        b2, _ := Open(testImportPath, ReadWrite)
+        err := b2.Import(&stream)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        got, _ := b2.Get("key2")
+        assertString(t, got, "value2")
+
+        b1.Close()
+        b2.Close()
+        os.RemoveAll(testBitcaskPath)
+        os.RemoveAll(testImportPath)
+    })
+
+    t.Run("cut binary stream", func(t *testing.T) {
+        b1, _ := Open(testBitcaskPath, ReadWrite)
+        b1.Put("key1", "value1")
+
+        var stream bytes.Buffer
+        b1.Export(&stream)
+        stream.Truncate(stream.Len() - 1)
+
+        b2, _ := Open(testImportPath, ReadWrite)
+        err := b2.Import(&stream)
+
+        assertError(t, err, "corrupted export stream")
+        b1.Close()
+        b2.Close()
+        os.RemoveAll(testBitcaskPath)
+        os.RemoveAll(testImportPath)
+    })
+
+    t.Run("unknown stream format", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        err := b.Import(bytes.NewBufferString("not an export"))
+
+        assertError(t, err, "unknown export format")
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("import refuses values over the maximum value size", func(t *testing.T) {
+        b1, _ := Open(testBitcaskPath, ReadWrite)
+        b1.Put("key1", "value1")
+        b1.Put("key2", "a longer value")
+
+        var binaryStream, jsonStream bytes.Buffer
+        b1.Export(&binaryStream)
+        b1.ExportJSON(&jsonStream)
+
+        b2, _ := Open(testImportPath, ReadWrite)
+        b2.SetMaxValueSize(10)
+        for _, stream := range []*bytes.Buffer{&binaryStream, &jsonStream} {
+            err := b2.Import(stream)
+            assertError(t, err, "key2: " + ValueTooLarge)
+        }
+        got, _ := b2.Get("key1")
+        assertString(t, got, "value1")
+
+        b1.Close()
+        b2.Close()
+        os.RemoveAll(testBitcaskPath)
+        os.RemoveAll(testImportPath)
+    })
+
+    t.Run("import does not block the datastore while reading the stream", func(t *testing.T) {
+        b1, _ := Open(testBitcaskPath, ReadWrite)
+        b1.Put("key1", "value1")
+        var stream bytes.Buffer
+        b1.ExportJSON(&stream)
+        b1.Close()
+
+        b2, _ := Open(testImportPath, ReadWrite)
+        r, w := io.Pipe()
+        done := make(chan error)
+        go func() {
+            done <- b2.Import(r)
+        }()
+        w.Write(stream.Bytes())
+
+        // The stream is still open, so Import is waiting for more records.
+        if err := b2.Put("key2", "value2"); err != nil {
+            t.Fatal(err)
+        }
+
+        w.Close()
+        if err := <-done; err != nil {
+            t.Fatal(err)
+        }
+        got, _ := b2.Get("key1")
+        assertString(t, got, "value1")
+        b2.Close()
+        os.RemoveAll(testBitcaskPath)
+        os.RemoveAll(testImportPath)
+    })
+
+    t.Run("export does not block the datastore while writing the stream", func(t *testing.T) {
+        b1, _ := Open(testBitcaskPath, ReadWrite)
+        b1.Put("key1", "value1")
+        r, w := io.Pipe()
+        done := make(chan error)
+        go func() {
+            err := b1.Export(w)
+            w.Close()
+            done <- err
+        }()
+
+        // Export waits for the rest of the stream to be read.
+        stream := make([]byte, 1)
+        io.ReadFull(r, stream)
+        if err := b1.Put("key2", "value2"); err != nil {
+            t.Fatal(err)
+        }
+
+        rest, _ := io.ReadAll(r)
+        stream = append(stream, rest...)
+        if err := <-done; err != nil {
+            t.Fatal(err)
+        }
+        b1.Close()
+
+        b2, _ := Open(testImportPath, ReadWrite)
+        if err := b2.Import(bytes.NewReader(stream)); err != nil {
+            t.Fatal(err)
+        }
+        got, _ := b2.Get("key1")
+        assertString(t, got, "value1")
+        _, err := b2.Get("key2")
+        assertError(t, err, "key2: " + KeyDoesNotExist)
+        b2.Close()
+        os.RemoveAll(testBitcaskPath)
+        os.RemoveAll(testImportPath)
+    })
+
+    t.Run("import with no write permission", func(t *testing.T) {
+        b1, _ := Open(testBitcaskPath, ReadWrite)
+        b1.Close()
+
+        b2, _ := Open(testBitcaskPath)
+        err := b2.Import(bytes.NewBufferString(exportMagic))
+
+        assertError(t, err, "write permission denied")
+        b2.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
@@ -0,0 +1,316 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+    // Error message when a datastore or one of its files has a format this version cannot read.
+    UnsupportedFormat = "unsupported datastore format"
+    // Error message when a datastore written by an older version is opened without write permission.
+    FormatNeedsMigration = "datastore has an older format, open it with ReadWrite once to migrate it"
+)
+
+const (
+    // Name of the file holding the format version of the datastore.
+    formatFile = ".format"
+    // Version of the record format written by this version.
+    formatVersion = 1
+
+    // Name of the file listing the files of a migration once its new data files are synced.
+    migrationFile = ".migration"
+    // Prefix of the new data files written by a migration until it is committed.
+    migrationFilePrefix = "migrating"
+
+    // Value older versions wrote to delete a key.
+    legacyTombstone = "DELETE THIS VALUE"
+    // Size of the tstamp, key size and value size fields starting the records of older versions.
+    legacyHeaderSize = 3 * numberFieldSize
+)
+
+// legacyRecord is a record written by a version older than the format file.
+type legacyRecord struct {
+    value string
+    tstamp int
+}
+
+// readFormat returns the format version of the datastore in dirPath on fsys, 0 when it has no format file.
+func readFormat(fsys FileSystem, dirPath string) (int, error) {
+    data, err := readFile(fsys, path.Join(dirPath, formatFile))
+    if os.IsNotExist(err) {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+
+    version, err := strconv.Atoi(strings.TrimSpace(string(data)))
+    if err != nil || version < 1 {
+        return 0, BitcaskError(fmt.Sprintf("%s: %s", dirPath, UnsupportedFormat))
+    }
+    return version, nil
+}
+
+// writeFormat marks the datastore in dirPath on fsys as written in the current format.
+func writeFormat(fsys FileSystem, dirPath string) error {
+    formatPath := path.Join(dirPath, formatFile)
+    if err := writeSyncedFile(fsys, formatPath + ".tmp", fmt.Sprintf("%d\n", formatVersion)); err != nil {
+        fsys.Remove(formatPath + ".tmp")
+        return err
+    }
+
+    return renameSynced(fsys, formatPath + ".tmp", formatPath)
+}
+
+// checkFormat returns an error unless the datastore in dirPath on fsys is in the current format.
+// A datastore without data files is in no format yet, it is marked with the current one when mark is set.
+func checkFormat(fsys FileSystem, dirPath string, mark bool) error {
+    version, err := readFormat(fsys, dirPath)
+    if err != nil {
+        return err
+    }
+    if version == formatVersion {
+        return nil
+    }
+    if version != 0 {
+        return BitcaskError(fmt.Sprintf("%s: %s", dirPath, UnsupportedFormat))
+    }
+
+    files, err := fsys.ReadDir(dirPath)
+    if err != nil {
+        return err
+    }
+    for _, file := range files {
+        if isDataFile(file.Name()) || file.Name() == migrationFile {
+            return BitcaskError(fmt.Sprintf("%s: %s", dirPath, FormatNeedsMigration))
+        }
+    }
+
+    if mark {
+        return writeFormat(fsys, dirPath)
+    }
+    return nil
+}
+
+// migrateFormat brings a datastore written by an older version to the current format, the caller is its only writer.
+// The live records of the legacy files are written into new data files under a migration name,
+// once they are synced the migration file lists the files to swap, so an interrupted migration is finished by the next open.
+// returns an error without changing a file if a legacy file cannot be read.
+func (b *Bitcask) migrateFormat() error {
+    version, err := readFormat(b.fs, b.datastorePath)
+    if err != nil {
+        return err
+    }
+    if version == formatVersion {
+        return nil
+    }
+    if version != 0 {
+        return BitcaskError(fmt.Sprintf("%s: %s", b.datastorePath, UnsupportedFormat))
+    }
+
+    migration, err := readFile(b.fs, path.Join(b.datastorePath, migrationFile))
+    if err == nil {
+        return b.finishMigration(migration)
+    }
+    if !os.IsNotExist(err) {
+        return err
+    }
+
+    files, err := b.fs.ReadDir(b.datastorePath)
+    if err != nil {
+        return err
+    }
+    var legacyFiles []string
+    for _, file := range files {
+        if isDataFile(file.Name()) {
+            legacyFiles = append(legacyFiles, file.Name())
+        }
+    }
+    if len(legacyFiles) == 0 {
+        return writeFormat(b.fs, b.datastorePath)
+    }
+    sort.Slice(legacyFiles, func(i, j int) bool {
+        return compareFileIds(legacyFiles[i], legacyFiles[j]) < 0
+    })
+
+    // Later records win, like they did when older versions replayed the files in order.
+    latest := make(map[string]legacyRecord)
+    for _, name := range legacyFiles {
+        fileData, err := readFile(b.fs, path.Join(b.datastorePath, name))
+        if err != nil {
+            return err
+        }
+        if err := readLegacyRecords(fileData, latest); err != nil {
+            return BitcaskError(fmt.Sprintf("%s: %s", name, err))
+        }
+    }
+
+    // Files left by an interrupted migration are written again.
+    for _, file := range files {
+        if strings.HasPrefix(file.Name(), migrationFilePrefix) {
+            if err := b.fs.Remove(path.Join(b.datastorePath, file.Name())); err != nil {
+                return err
+            }
+        }
+    }
+
+    migrated, err := b.writeMigratedFiles(latest)
+    if err != nil {
+        return err
+    }
+
+    migrationPath := path.Join(b.datastorePath, migrationFile)
+    content := strings.Join(append(legacyFiles, migrated...), "\n") + "\n"
+    if err := writeSyncedFile(b.fs, migrationPath + ".tmp", content); err != nil {
+        return err
+    }
+    if err := renameSynced(b.fs, migrationPath + ".tmp", migrationPath); err != nil {
+        return err
+    }
+
+    return b.finishMigration([]byte(content))
+}
+
+// writeMigratedFiles writes the live legacy records into synced data files under a migration name and returns their names.
+// The records get new sequence numbers and are compressed and encrypted like new writes.
+func (b *Bitcask) writeMigratedFiles(latest map[string]legacyRecord) ([]string, error) {
+    if err := b.loadSequence(); err != nil {
+        return nil, err
+    }
+
+    keys := make([]string, 0, len(latest))
+    for key, legacyRec := range latest {
+        if legacyRec.value != legacyTombstone {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+
+    var migrated []string
+    var file File
+    var currentPos int
+    closeFile := func() error {
+        if file == nil {
+            return nil
+        }
+        defer file.Close()
+        return file.Sync()
+    }
+
+    for _, key := range keys {
+        legacyRec := latest[key]
+        storedValue, flags, err := b.encodeValue(legacyRec.value)
+        if err != nil {
+            closeFile()
+            return nil, err
+        }
+        storedKey, storedValue, flags, err := b.sealRecord(key, storedValue, flags)
+        if err != nil {
+            closeFile()
+            return nil, err
+        }
+        b.seq++
+        fileLine := string(compressFileLine(storedKey, storedValue, b.seq, legacyRec.tstamp, flags))
+
+        if file == nil || currentPos + len(fileLine) + 1 > maxFileSize {
+            if err := closeFile(); err != nil {
+                return nil, err
+            }
+            fileName, err := b.nextFileName()
+            if err != nil {
+                return nil, err
+            }
+            migratedName := migrationFilePrefix + fileName
+            file, err = b.fs.OpenFile(path.Join(b.datastorePath, migratedName), os.O_CREATE | os.O_TRUNC | os.O_WRONLY)
+            if err != nil {
+                return nil, err
+            }
+            migrated = append(migrated, migratedName)
+            currentPos = 0
+        }
+
+        n, err := fmt.Fprintln(file, fileLine)
+        if err != nil {
+            closeFile()
+            return nil, err
+        }
+        currentPos += n
+    }
+    if err := closeFile(); err != nil {
+        return nil, err
+    }
+
+    return migrated, writeSequence(b.fs, b.datastorePath, b.nextFileId, b.seq)
+}
+
+// finishMigration swaps the files listed by a committed migration and marks the datastore with the current format.
+// Files already swapped by an interrupted run are skipped.
+func (b *Bitcask) finishMigration(migration []byte) error {
+    for _, name := range strings.Fields(string(migration)) {
+        if strings.HasPrefix(name, migrationFilePrefix) {
+            migratedPath := path.Join(b.datastorePath, name)
+            err := b.fs.Rename(migratedPath, path.Join(b.datastorePath, strings.TrimPrefix(name, migrationFilePrefix)))
+            if err != nil && !os.IsNotExist(err) {
+                return err
+            }
+            continue
+        }
+
+        for _, legacyFile := range []string{name, hintFilePrefix + name} {
+            if err := b.fs.Remove(path.Join(b.datastorePath, legacyFile)); err != nil && !os.IsNotExist(err) {
+                return err
+            }
+        }
+    }
+
+    if err := writeFormat(b.fs, b.datastorePath); err != nil {
+        return err
+    }
+    return b.fs.Remove(path.Join(b.datastorePath, migrationFile))
+}
+
+// readLegacyRecords adds the records of a data file written by an older version to latest.
+// A damaged tail is dropped, but a file whose first record does not parse is not in the legacy format.
+func readLegacyRecords(fileData []byte, latest map[string]legacyRecord) error {
+    var currentPos int
+    for currentPos < len(fileData) {
+        line := fileData[currentPos:]
+        tstamp, keySize, valueSize, ok := legacyHeader(line)
+        if !ok {
+            if currentPos == 0 {
+                return BitcaskError(UnsupportedFormat)
+            }
+            break
+        }
+
+        key := string(line[legacyHeaderSize:legacyHeaderSize + keySize])
+        latest[key] = legacyRecord{
+            value:  string(line[legacyHeaderSize + keySize:legacyHeaderSize + keySize + valueSize]),
+            tstamp: tstamp,
+        }
+        currentPos += legacyHeaderSize + keySize + valueSize + 1
+    }
+
+    return nil
+}
+
+// legacyHeader returns the tstamp, key size and value size of a record written by an older version,
+// ok is false unless the whole record and its line ending are in line.
+func legacyHeader(line []byte) (tstamp int, keySize int, valueSize int, ok bool) {
+    if len(line) <= legacyHeaderSize {
+        return 0, 0, 0, false
+    }
+    tstamp, tstampErr := strconv.Atoi(string(line[:numberFieldSize]))
+    keySize, keySizeErr := strconv.Atoi(string(line[numberFieldSize:2 * numberFieldSize]))
+    valueSize, valueSizeErr := strconv.Atoi(string(line[2 * numberFieldSize:legacyHeaderSize]))
+    if tstampErr != nil || keySizeErr != nil || valueSizeErr != nil || keySize < 0 || valueSize < 0 ||
+    keySize + valueSize >= len(line) - legacyHeaderSize || line[legacyHeaderSize + keySize + valueSize] != '\n' {
+        return 0, 0, 0, false
+    }
+    return tstamp, keySize, valueSize, true
+}
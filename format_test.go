@@ -0,0 +1,121 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+// legacyFileLine builds a record the way versions older than the format file wrote it.
+func legacyFileLine(key string, value string, tstamp int) string {
+    return padWithZero(tstamp) + padWithZero(len(key)) + padWithZero(len(value)) + key + value + "\n"
+}
+
+// writeLegacyDatastore writes a datastore in the layout of versions older than the format file.
+func writeLegacyDatastore(t *testing.T) {
+    t.Helper()
+    os.MkdirAll(testBitcaskPath, dirMode)
+    files := map[string]string{
+        "1700000000000001": legacyFileLine("key1", "value1", 1) + legacyFileLine("key2", "value2", 2),
+        "1700000000000002": legacyFileLine("key1", "new", 3) + legacyFileLine("key2", legacyTombstone, 4) + "00000",
+    }
+    for name, content := range files {
+        if err := os.WriteFile(path.Join(testBitcaskPath, name), []byte(content), fileMode); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func TestFormat(t *testing.T) {
+    t.Run("older datastore is migrated by a writer", func(t *testing.T) {
+        writeLegacyDatastore(t)
+        // A file of a migration stopped before its commit is written again.
+        os.WriteFile(path.Join(testBitcaskPath, migrationFilePrefix + "1"), []byte("partial"), fileMode)
+
+        b, err := Open(testBitcaskPath, ReadWrite)
+        if err != nil {
+            t.Fatal(err)
+        }
+        got, _ := b.Get("key1")
+        assertString(t, got, "new")
+        _, err = b.Get("key2")
+        assertError(t, err, "key2: " + KeyDoesNotExist)
+        b.Close()
+
+        for _, name := range []string{"1700000000000001", "1700000000000002", migrationFile, migrationFilePrefix + "1"} {
+            if _, err := os.Stat(path.Join(testBitcaskPath, name)); !os.IsNotExist(err) {
+                t.Errorf("expected %s to be removed by the migration, got %v", name, err)
+            }
+        }
+        report, err := Verify(testBitcaskPath)
+        if err != nil || !report.Healthy() {
+            t.Errorf("expected a healthy datastore after the migration, got %+v, %v", report, err)
+        }
+
+        b, _ = Open(testBitcaskPath)
+        got, _ = b.Get("key1")
+        assertString(t, got, "new")
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("older datastore is refused by a reader", func(t *testing.T) {
+        writeLegacyDatastore(t)
+
+        _, err := Open(testBitcaskPath)
+        assertError(t, err, testBitcaskPath + ": " + FormatNeedsMigration)
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("unknown files are neither opened nor repaired", func(t *testing.T) {
+        os.MkdirAll(testBitcaskPath, dirMode)
+        dataPath := path.Join(testBitcaskPath, "1")
+        content := []byte("records of some other layout\n")
+        os.WriteFile(dataPath, content, fileMode)
+
+        _, err := Open(testBitcaskPath, ReadWrite)
+        assertError(t, err, "1: " + UnsupportedFormat)
+        _, err = Repair(testBitcaskPath)
+        assertError(t, err, testBitcaskPath + ": " + FormatNeedsMigration)
+
+        if got, _ := os.ReadFile(dataPath); string(got) != string(content) {
+            t.Errorf("expected the unknown file to be kept, got %q", got)
+        }
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("newer format is refused", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        b.Close()
+        os.WriteFile(path.Join(testBitcaskPath, formatFile), []byte(fmt.Sprintf("%d\n", formatVersion + 1)), fileMode)
+
+        _, err := Open(testBitcaskPath, ReadWrite)
+        assertError(t, err, testBitcaskPath + ": " + UnsupportedFormat)
+        _, err = Verify(testBitcaskPath)
+        assertError(t, err, testBitcaskPath + ": " + UnsupportedFormat)
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("interrupted migration is finished on open", func(t *testing.T) {
+        writeLegacyDatastore(t)
+        // The migration was committed but stopped before its files were swapped.
+        migrated := string(compressFileLine("key1", "migrated", 1, 3, 0)) + "\n"
+        os.WriteFile(path.Join(testBitcaskPath, migrationFilePrefix + "1700000000000003"), []byte(migrated), fileMode)
+        os.WriteFile(path.Join(testBitcaskPath, migrationFile),
+        []byte("1700000000000001\n1700000000000002\n" + migrationFilePrefix + "1700000000000003\n"), fileMode)
+
+        b, err := Open(testBitcaskPath, ReadWrite)
+        if err != nil {
+            t.Fatal(err)
+        }
+        got, _ := b.Get("key1")
+        assertString(t, got, "migrated")
+        if len(b.ListKeys()) != 1 {
+            t.Errorf("got keys %v, want only key1", b.ListKeys())
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
@@ -0,0 +1,466 @@
+package bitcask
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileSystem is the storage a bitcask datastore keeps its files on.
+// Names are slash separated paths like the datastore path given to Open.
+type FileSystem interface {
+    // Open opens the named file for reading.
+    Open(name string) (File, error)
+    // OpenFile opens the named file with os.O_* flags, O_CREATE creates it and O_EXCL fails if it exists.
+    OpenFile(name string, flag int) (File, error)
+    // Rename moves oldName to newName, replacing newName if it exists.
+    Rename(oldName string, newName string) error
+    // Remove removes the named file or empty directory.
+    Remove(name string) error
+    // ReadDir lists the named directory sorted by file name.
+    ReadDir(name string) ([]os.FileInfo, error)
+    // MkdirAll creates the named directory and any missing parents.
+    MkdirAll(name string) error
+    // Lock creates the named lock file, it fails if the file exists. The lock is released by removing the file.
+    Lock(name string) error
+    // SyncDir commits the files created, renamed and removed in the named directory to stable storage.
+    SyncDir(name string) error
+}
+
+// File is a file opened on a FileSystem.
+type File interface {
+    io.Reader
+    io.ReaderAt
+    io.Writer
+    io.WriterAt
+    io.Seeker
+    io.Closer
+    // Stat returns the size and name of the file.
+    Stat() (os.FileInfo, error)
+    // Sync commits the written content to stable storage.
+    Sync() error
+    // Truncate changes the size of the file.
+    Truncate(size int64) error
+}
+
+// OSFileSystem keeps the files on the local disk through the os package, it is the default FileSystem.
+var OSFileSystem FileSystem = osFileSystem{}
+
+// osFileSystem implements FileSystem with the os package.
+type osFileSystem struct{}
+
+func (osFileSystem) Open(name string) (File, error) {
+    return os.Open(name)
+}
+
+func (osFileSystem) OpenFile(name string, flag int) (File, error) {
+    return os.OpenFile(name, flag, fileMode)
+}
+
+func (osFileSystem) Rename(oldName string, newName string) error {
+    return os.Rename(oldName, newName)
+}
+
+func (osFileSystem) Remove(name string) error {
+    return os.Remove(name)
+}
+
+func (osFileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+    entries, err := os.ReadDir(name)
+    if err != nil {
+        return nil, err
+    }
+
+    infos := make([]os.FileInfo, 0, len(entries))
+    for _, entry := range entries {
+        info, err := entry.Info()
+        // Files removed since the directory was read are left out.
+        if os.IsNotExist(err) {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        infos = append(infos, info)
+    }
+    return infos, nil
+}
+
+func (osFileSystem) MkdirAll(name string) error {
+    return os.MkdirAll(name, dirMode)
+}
+
+func (osFileSystem) Lock(name string) error {
+    file, err := os.OpenFile(name, os.O_CREATE | os.O_EXCL, fileMode)
+    if err != nil {
+        return err
+    }
+    return file.Close()
+}
+
+func (osFileSystem) SyncDir(name string) error {
+    dir, err := os.Open(name)
+    if err != nil {
+        return err
+    }
+    defer dir.Close()
+
+    return dir.Sync()
+}
+
+// readFile reads the whole named file from fsys.
+func readFile(fsys FileSystem, name string) ([]byte, error) {
+    file, err := fsys.Open(name)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return io.ReadAll(file)
+}
+
+// statFile returns the size and name of the named file of fsys.
+func statFile(fsys FileSystem, name string) (os.FileInfo, error) {
+    file, err := fsys.Open(name)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return file.Stat()
+}
+
+// renameSynced moves oldName to newName on fsys and syncs the directory of newName,
+// so the file is found under its new name after a crash.
+func renameSynced(fsys FileSystem, oldName string, newName string) error {
+    if err := fsys.Rename(oldName, newName); err != nil {
+        return err
+    }
+    return fsys.SyncDir(path.Dir(newName))
+}
+
+// truncateFile cuts the named file of fsys to size bytes.
+func truncateFile(fsys FileSystem, name string, size int64) error {
+    file, err := fsys.OpenFile(name, os.O_WRONLY)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    return file.Truncate(size)
+}
+
+// memFileSystem implements FileSystem in memory, the files are gone with it.
+type memFileSystem struct {
+    mu sync.Mutex
+    files map[string]*memData
+    dirs map[string]bool
+}
+
+// memData is the content of a file of a memFileSystem, it outlives its name like an unlinked file.
+type memData struct {
+    data []byte
+    modTime time.Time
+}
+
+// memFile is a file opened on a memFileSystem.
+type memFile struct {
+    fs *memFileSystem
+    name string
+    file *memData
+    pos int64
+    flag int
+    closed bool
+}
+
+// memFileInfo describes a file or directory of a memFileSystem.
+type memFileInfo struct {
+    name string
+    size int64
+    modTime time.Time
+    isDir bool
+}
+
+// NewMemFileSystem returns an empty FileSystem kept in memory,
+// for tests and datastores that do not need to outlive the process.
+func NewMemFileSystem() FileSystem {
+    return &memFileSystem{
+        files: make(map[string]*memData),
+        dirs:  map[string]bool{".": true, "/": true},
+    }
+}
+
+// pathError returns the error os functions return for op on name.
+func pathError(op string, name string, err error) error {
+    return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+func (m *memFileSystem) Open(name string) (File, error) {
+    return m.OpenFile(name, os.O_RDONLY)
+}
+
+func (m *memFileSystem) OpenFile(name string, flag int) (File, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    name = path.Clean(name)
+    file, isExist := m.files[name]
+    switch {
+    case isExist && flag & os.O_CREATE != 0 && flag & os.O_EXCL != 0:
+        return nil, pathError("open", name, os.ErrExist)
+    case !isExist && m.dirs[name]:
+        return nil, pathError("open", name, os.ErrInvalid)
+    case !isExist && flag & os.O_CREATE == 0:
+        return nil, pathError("open", name, os.ErrNotExist)
+    case !isExist && !m.dirs[path.Dir(name)]:
+        return nil, pathError("open", name, os.ErrNotExist)
+    case !isExist:
+        file = &memData{modTime: time.Now()}
+        m.files[name] = file
+    }
+
+    if flag & os.O_TRUNC != 0 {
+        file.data = nil
+    }
+    return &memFile{fs: m, name: name, file: file, flag: flag}, nil
+}
+
+func (m *memFileSystem) Rename(oldName string, newName string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    oldName, newName = path.Clean(oldName), path.Clean(newName)
+    file, isExist := m.files[oldName]
+    if !isExist {
+        return pathError("rename", oldName, os.ErrNotExist)
+    }
+    if !m.dirs[path.Dir(newName)] {
+        return pathError("rename", newName, os.ErrNotExist)
+    }
+
+    delete(m.files, oldName)
+    m.files[newName] = file
+    return nil
+}
+
+func (m *memFileSystem) Remove(name string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    name = path.Clean(name)
+    if _, isExist := m.files[name]; isExist {
+        delete(m.files, name)
+        return nil
+    }
+    if !m.dirs[name] {
+        return pathError("remove", name, os.ErrNotExist)
+    }
+    if len(m.list(name)) > 0 {
+        return pathError("remove", name, os.ErrExist)
+    }
+    delete(m.dirs, name)
+    return nil
+}
+
+func (m *memFileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    name = path.Clean(name)
+    if !m.dirs[name] {
+        return nil, pathError("open", name, os.ErrNotExist)
+    }
+    return m.list(name), nil
+}
+
+// list describes the files and directories directly in dir sorted by name, the caller holds the lock.
+func (m *memFileSystem) list(dir string) []os.FileInfo {
+    var infos []os.FileInfo
+
+    for name, file := range m.files {
+        if path.Dir(name) == dir {
+            infos = append(infos, memFileInfo{name: path.Base(name), size: int64(len(file.data)), modTime: file.modTime})
+        }
+    }
+    for name := range m.dirs {
+        if name != dir && path.Dir(name) == dir {
+            infos = append(infos, memFileInfo{name: path.Base(name), isDir: true})
+        }
+    }
+    sort.Slice(infos, func(i, j int) bool {
+        return infos[i].Name() < infos[j].Name()
+    })
+
+    return infos
+}
+
+func (m *memFileSystem) MkdirAll(name string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    for name = path.Clean(name); !m.dirs[name]; name = path.Dir(name) {
+        if _, isExist := m.files[name]; isExist {
+            return pathError("mkdir", name, os.ErrExist)
+        }
+        m.dirs[name] = true
+    }
+    return nil
+}
+
+func (m *memFileSystem) Lock(name string) error {
+    file, err := m.OpenFile(name, os.O_CREATE | os.O_EXCL | os.O_WRONLY)
+    if err != nil {
+        return err
+    }
+    return file.Close()
+}
+
+// SyncDir has nothing to do, the files are not kept on stable storage.
+func (m *memFileSystem) SyncDir(name string) error {
+    return nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+    n, err := f.ReadAt(p, f.pos)
+    f.pos += int64(n)
+    if err == io.EOF && n > 0 {
+        err = nil
+    }
+    return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+    f.fs.mu.Lock()
+    defer f.fs.mu.Unlock()
+
+    if f.closed {
+        return 0, pathError("read", f.name, os.ErrClosed)
+    }
+    if off >= int64(len(f.file.data)) {
+        return 0, io.EOF
+    }
+    n := copy(p, f.file.data[off:])
+    if n < len(p) {
+        return n, io.EOF
+    }
+    return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+    if f.flag & os.O_APPEND != 0 {
+        f.fs.mu.Lock()
+        f.pos = int64(len(f.file.data))
+        f.fs.mu.Unlock()
+    }
+    n, err := f.WriteAt(p, f.pos)
+    f.pos += int64(n)
+    return n, err
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+    f.fs.mu.Lock()
+    defer f.fs.mu.Unlock()
+
+    if f.closed {
+        return 0, pathError("write", f.name, os.ErrClosed)
+    }
+    if f.flag & (os.O_WRONLY | os.O_RDWR) == 0 {
+        return 0, pathError("write", f.name, os.ErrPermission)
+    }
+    if end := off + int64(len(p)); end > int64(len(f.file.data)) {
+        f.file.data = append(f.file.data, make([]byte, end - int64(len(f.file.data)))...)
+    }
+    copy(f.file.data[off:], p)
+    f.file.modTime = time.Now()
+    return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+    f.fs.mu.Lock()
+    defer f.fs.mu.Unlock()
+
+    switch whence {
+    case io.SeekCurrent:
+        offset += f.pos
+    case io.SeekEnd:
+        offset += int64(len(f.file.data))
+    }
+    if offset < 0 {
+        return 0, pathError("seek", f.name, os.ErrInvalid)
+    }
+    f.pos = offset
+    return offset, nil
+}
+
+func (f *memFile) Close() error {
+    f.fs.mu.Lock()
+    defer f.fs.mu.Unlock()
+
+    if f.closed {
+        return pathError("close", f.name, os.ErrClosed)
+    }
+    f.closed = true
+    return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+    f.fs.mu.Lock()
+    defer f.fs.mu.Unlock()
+
+    return memFileInfo{name: path.Base(f.name), size: int64(len(f.file.data)), modTime: f.file.modTime}, nil
+}
+
+func (f *memFile) Sync() error {
+    f.fs.mu.Lock()
+    defer f.fs.mu.Unlock()
+
+    if f.closed {
+        return pathError("sync", f.name, os.ErrClosed)
+    }
+    return nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+    f.fs.mu.Lock()
+    defer f.fs.mu.Unlock()
+
+    if f.closed {
+        return pathError("truncate", f.name, os.ErrClosed)
+    }
+    if size < int64(len(f.file.data)) {
+        f.file.data = f.file.data[:size:size]
+    } else {
+        f.file.data = append(f.file.data, make([]byte, size - int64(len(f.file.data)))...)
+    }
+    return nil
+}
+
+func (i memFileInfo) Name() string {
+    return i.name
+}
+
+func (i memFileInfo) Size() int64 {
+    return i.size
+}
+
+func (i memFileInfo) Mode() os.FileMode {
+    if i.isDir {
+        return os.ModeDir | dirMode
+    }
+    return fileMode
+}
+
+func (i memFileInfo) ModTime() time.Time {
+    return i.modTime
+}
+
+func (i memFileInfo) IsDir() bool {
+    return i.isDir
+}
+
+func (i memFileInfo) Sys() any {
+    return nil
+}
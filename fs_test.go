@@ -0,0 +1,192 @@
+package bitcask
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"testing"
+)
+
+// lockFailFS is a FileSystem whose locks cannot be taken.
+type lockFailFS struct {
+    FileSystem
+}
+
+func (lockFailFS) Lock(name string) error {
+    return errors.New("lock failed")
+}
+
+func TestMemFileSystem(t *testing.T) {
+    t.Run("datastore lives in memory", func(t *testing.T) {
+        fsys := NewMemFileSystem()
+        b, err := OpenFS(fsys, testBitcaskPath, nil, ReadWrite)
+        if err != nil {
+            t.Fatal(err)
+        }
+        b.Put("key1", "value1")
+        b.Put("key2", "value2")
+        fillActiveFile(b)
+        b.Delete("key2")
+        b.Merge()
+        b.Put("key3", "value3")
+        b.Close()
+
+        if _, err := os.Stat(testBitcaskPath); !os.IsNotExist(err) {
+            t.Errorf("expected nothing written to disk, got %v", err)
+        }
+
+        b, err = OpenFS(fsys, testBitcaskPath, nil, ReadWrite)
+        if err != nil {
+            t.Fatal(err)
+        }
+        got, _ := b.Get("key1")
+        assertString(t, got, "value1")
+        got, _ = b.Get("key3")
+        assertString(t, got, "value3")
+        if _, err := b.Get("key2"); err == nil {
+            t.Errorf("expected deleted key to stay deleted")
+        }
+        b.Close()
+
+        r, err := OpenFS(fsys, testBitcaskPath, nil, ReadOnly)
+        if err != nil {
+            t.Fatal(err)
+        }
+        got, _ = r.Get("key1")
+        assertString(t, got, "value1")
+        r.Close()
+    })
+
+    t.Run("offline tools work on the file system", func(t *testing.T) {
+        fsys := NewMemFileSystem()
+        if err := bulkLoad(fsys, testBitcaskPath, &countIterator{n: 10}); err != nil {
+            t.Fatal(err)
+        }
+
+        b, err := OpenFS(fsys, testBitcaskPath, nil, ReadWrite)
+        if err != nil {
+            t.Fatal(err)
+        }
+        b.Put("key11", "value11")
+        if err := b.Backup(testBackupPath); err != nil {
+            t.Fatal(err)
+        }
+        fileId := b.activeFile.fileName
+        b.Put("key12", "value12")
+        b.Close()
+
+        tail := OpenTail(testBitcaskPath, "key", fileId, 0)
+        tail.SetFileSystem(fsys)
+        events, err := tail.Next()
+        if err != nil || len(events) != 1 || events[0].Key != "key12" {
+            t.Errorf("got events %+v, %v, want key12", events, err)
+        }
+
+        restorePath := testBitcaskPath + "_restored"
+        if err := restore(fsys, testBackupPath, restorePath); err != nil {
+            t.Fatal(err)
+        }
+        // The file of key11 gets a damaged tail.
+        files, _ := fsys.ReadDir(restorePath)
+        var lastFile string
+        for _, file := range files {
+            if isDataFile(file.Name()) && (lastFile == "" || compareFileIds(file.Name(), lastFile) > 0) {
+                lastFile = file.Name()
+            }
+        }
+        file, _ := fsys.OpenFile(path.Join(restorePath, lastFile), os.O_WRONLY | os.O_APPEND)
+        file.Write([]byte("damaged"))
+        file.Close()
+
+        report, err := verifyDatastore(fsys, restorePath, true)
+        if err != nil || len(report.Problems) != 1 || len(report.Repaired) == 0 || report.Repaired[0] != lastFile {
+            t.Errorf("expected the damaged file to be repaired, got %+v, %v", report, err)
+        }
+        if report, err := verifyDatastore(fsys, restorePath, false); err != nil || !report.Healthy() {
+            t.Errorf("expected a healthy datastore after the repair, got %+v, %v", report, err)
+        }
+
+        b, err = OpenFS(fsys, restorePath, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(b.ListKeys()) != 11 {
+            t.Errorf("got %d keys, want the 11 keys of the backup", len(b.ListKeys()))
+        }
+        b.Close()
+
+        if _, err := os.Stat(testBitcaskPath); !os.IsNotExist(err) {
+            t.Errorf("expected nothing written to disk, got %v", err)
+        }
+    })
+
+    t.Run("directories are synced on the os file system", func(t *testing.T) {
+        if err := OSFileSystem.SyncDir(t.TempDir()); err != nil {
+            t.Errorf("expected the directory to be synced, got %v", err)
+        }
+        if err := OSFileSystem.SyncDir(path.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+            t.Errorf("expected missing directory error, got %v", err)
+        }
+    })
+
+    t.Run("open fails when the lock cannot be taken", func(t *testing.T) {
+        fsys := NewMemFileSystem()
+        b, _ := OpenFS(fsys, testBitcaskPath, nil, ReadWrite)
+        b.Put("key1", "value1")
+        b.Close()
+
+        for _, opts := range [][]ConfigOpt{{ReadWrite}, {ReadOnly}} {
+            if _, err := OpenFS(lockFailFS{fsys}, testBitcaskPath, nil, opts...); err == nil || err.Error() != "lock failed" {
+                t.Errorf("options %v: expected the lock error, got %v", opts, err)
+            }
+        }
+        if _, err := OpenFS(lockFailFS{NewMemFileSystem()}, testBitcaskPath, nil, ReadWrite); err == nil {
+            t.Errorf("expected creating a datastore to fail without the lock")
+        }
+    })
+
+    t.Run("read only cannot create a datastore", func(t *testing.T) {
+        _, err := OpenFS(NewMemFileSystem(), testBitcaskPath, nil, ReadOnly)
+        assertError(t, err, CannotCreateBitcask)
+    })
+
+    t.Run("files behave like os files", func(t *testing.T) {
+        fsys := NewMemFileSystem()
+        if _, err := fsys.OpenFile("dir/file", os.O_CREATE | os.O_WRONLY); !os.IsNotExist(err) {
+            t.Errorf("expected missing directory error, got %v", err)
+        }
+        fsys.MkdirAll("dir")
+
+        file, _ := fsys.OpenFile("dir/file", os.O_CREATE | os.O_RDWR)
+        file.Write([]byte("hello world"))
+        if err := fsys.Lock("dir/file"); !os.IsExist(err) {
+            t.Errorf("expected lock on an existing file to fail, got %v", err)
+        }
+
+        fsys.Rename("dir/file", "dir/renamed")
+        fsys.Remove("dir/renamed")
+        // Open files keep their content once removed, like on unix.
+        buf := make([]byte, 5)
+        if _, err := file.ReadAt(buf, 6); err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, string(buf), "world")
+        file.Close()
+
+        if _, err := file.Write([]byte("x")); err == nil {
+            t.Errorf("expected write to a closed file to fail")
+        }
+        if files, _ := fsys.ReadDir("dir"); len(files) != 0 {
+            t.Errorf("expected empty directory, got %d files", len(files))
+        }
+
+        file, _ = fsys.OpenFile("dir/other", os.O_CREATE | os.O_RDWR | os.O_APPEND)
+        file.Write([]byte("abc"))
+        file.Seek(0, io.SeekStart)
+        file.Write([]byte("def"))
+        data, _ := readFile(fsys, "dir/other")
+        assertString(t, string(data), "abcdef")
+        file.Close()
+    })
+}
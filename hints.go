@@ -0,0 +1,51 @@
+package bitcask
+
+import (
+	"path"
+)
+
+// GenerateHints writes a hint file for every sealed data file that has none,
+// so the next Open loads the keydir from hint files instead of replaying the data files.
+// It waits for a running merge, which writes the hint files of its own files.
+// returns an error if ReadWrite permission is not set.
+func (b *Bitcask) GenerateHints() error {
+    if b.config.writePermission == ReadOnly {
+        return BitcaskError(WriteDenied)
+    }
+
+    b.merges.running.Lock()
+    defer b.merges.running.Unlock()
+
+    // The read lock keeps the active file from being sealed meanwhile.
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    files, err := b.fs.ReadDir(b.datastorePath)
+    if err != nil {
+        return err
+    }
+
+    hintFiles := make(map[string]bool)
+    for _, file := range files {
+        if isHintFile(file.Name()) {
+            hintFiles[file.Name()] = true
+        }
+    }
+
+    for _, file := range files {
+        name := file.Name()
+        if !isDataFile(name) || name == b.activeFile.fileName || hintFiles[hintFilePrefix + name] {
+            continue
+        }
+
+        fileData, err := readFile(b.fs, path.Join(b.datastorePath, name))
+        if err != nil {
+            return err
+        }
+        if err := writeHintFile(b.fs, b.datastorePath, name, fileData); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
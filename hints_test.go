@@ -0,0 +1,73 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestHints(t *testing.T) {
+    t.Run("rotation and close write hint files", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        for i := 0; i < 500; i++ {
+            b.Put(fmt.Sprintf("key%d", i + 1), "value")
+        }
+        b.Delete("key1")
+
+        dataFiles, _ := listDataFiles(testBitcaskPath)
+        hintFiles, _ := listFilesWithPrefix(testBitcaskPath, hintFilePrefix)
+        if len(hintFiles) != len(dataFiles) - 1 {
+            t.Errorf("got %d hint files for %d data files, want one per sealed file", len(hintFiles), len(dataFiles))
+        }
+
+        b.Close()
+        hintFiles, _ = listFilesWithPrefix(testBitcaskPath, hintFilePrefix)
+        if len(hintFiles) != len(dataFiles) {
+            t.Errorf("got %d hint files for %d data files after close", len(hintFiles), len(dataFiles))
+        }
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        if len(b.ListKeys()) != 499 {
+            t.Errorf("got %d keys, want 499", len(b.ListKeys()))
+        }
+        b.Close()
+
+        report, _ := Verify(testBitcaskPath)
+        if !report.Healthy() {
+            t.Errorf("expected healthy datastore, got: %+v", report)
+        }
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("generate hints for an existing store", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        for i := 0; i < 500; i++ {
+            b.Put(fmt.Sprintf("key%d", i + 1), "value")
+        }
+        b.Close()
+
+        hintFiles, _ := listFilesWithPrefix(testBitcaskPath, hintFilePrefix)
+        for _, hintFile := range hintFiles {
+            os.Remove(hintFile)
+        }
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        if err := b.GenerateHints(); err != nil {
+            t.Fatal(err)
+        }
+        dataFiles, _ := listDataFiles(testBitcaskPath)
+        hintFiles, _ = listFilesWithPrefix(testBitcaskPath, hintFilePrefix)
+        if len(hintFiles) != len(dataFiles) - 1 {
+            t.Errorf("got %d hint files for %d data files, want one per sealed file", len(hintFiles), len(dataFiles))
+        }
+        b.Close()
+
+        r, _ := Open(testBitcaskPath)
+        assertError(t, r.GenerateHints(), WriteDenied)
+        if len(r.ListKeys()) != 500 {
+            t.Errorf("got %d keys, want 500", len(r.ListKeys()))
+        }
+        r.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
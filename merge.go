@@ -0,0 +1,538 @@
+package bitcask
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Error message when a merge is asked for a file that is not a sealed data file of the datastore.
+const NotASealedFile = "not a sealed data file"
+
+// Prefix of the names merge files are written under until they are synced,
+// so Backup, replication and hint generation never see a partly written one.
+const mergeFilePrefix = "merging"
+
+// MergePolicy decides which data files MergeFragmented compacts, after the knobs of the Bitcask paper.
+// A file is merged once either limit is reached, a limit of 0 is not checked.
+type MergePolicy struct {
+    // FragMergeTrigger is the percentage of dead bytes in a file from which it is merged.
+    FragMergeTrigger int
+    // DeadBytesThreshold is the number of dead bytes in a file from which it is merged whatever its size.
+    DeadBytesThreshold int64
+}
+
+// DefaultMergePolicy merges files with 60% or 128MB of dead bytes.
+var DefaultMergePolicy = MergePolicy{FragMergeTrigger: 60, DeadBytesThreshold: 128 * 1024 * 1024}
+
+// fileUsage is the size of a sealed data file and the part of it the keydir still points to.
+type fileUsage struct {
+    size int64
+    live int64
+}
+
+// MergePlan describes what Merge would do to the datastore, it is computed without writing anything.
+type MergePlan struct {
+    Files []MergePlanFile `json:"files"`
+    // OutputFiles is the number of files Merge would write the live records of the sealed files to.
+    OutputFiles int `json:"output_files"`
+    // SavedBytes is the size of the sealed files less the size of the files Merge would write.
+    SavedBytes int64 `json:"saved_bytes"`
+}
+
+// MergePlanFile is the usage of a data file, the active file is never merged.
+type MergePlanFile struct {
+    FileId string `json:"file_id"`
+    Active bool `json:"active"`
+    // Fragmented tells whether MergeFragmented would merge the file under the current merge policy.
+    Fragmented bool `json:"fragmented"`
+    Bytes int64 `json:"bytes"`
+    LiveBytes int64 `json:"live_bytes"`
+    DeadBytes int64 `json:"dead_bytes"`
+}
+
+// mergeWriter writes the files produced by a merge, a new file is started when a record does not fit.
+type mergeWriter struct {
+    b *Bitcask
+    file File
+    fileName string
+    currentPos int
+    hintData strings.Builder
+    ctx context.Context
+    limiter *rateLimiter
+    // Names of the files written so far, removed again when the merge fails.
+    written []string
+}
+
+// SetMergePolicy sets the limits MergeFragmented uses to pick the files it merges.
+func (b *Bitcask) SetMergePolicy(policy MergePolicy) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.config.mergePolicy = policy
+}
+
+// MergeFiles rewrites the live records of the given sealed data files into new compact files and removes them,
+// the other files are left untouched. Tombstones are kept while older files may still hold the deleted keys.
+// returns an error if ReadWrite permission is not set or a file id is not a sealed data file.
+func (b *Bitcask) MergeFiles(fileIds []string) error {
+    if b.config.writePermission == ReadOnly {
+        return BitcaskError(WriteDenied)
+    }
+
+    b.merges.running.Lock()
+    defer b.merges.running.Unlock()
+
+    usage, err := b.sealedFileUsage()
+    if err != nil {
+        return err
+    }
+
+    selected := make(map[string]bool)
+    for _, fileId := range fileIds {
+        if _, isExist := usage[fileId]; !isExist {
+            return BitcaskError(fmt.Sprintf("%s: %s", fileId, NotASealedFile))
+        }
+        selected[fileId] = true
+    }
+    if len(selected) == 0 {
+        return nil
+    }
+
+    return b.mergeFiles(context.Background(), selected, len(selected) < len(usage), nil)
+}
+
+// MergeFragmented merges the sealed data files whose dead bytes reach a limit of the merge policy,
+// files that are already compact are left untouched. Nothing is written when no file reaches a limit.
+// returns an error if ReadWrite permission is not set.
+func (b *Bitcask) MergeFragmented() error {
+    return b.mergeFragmented(context.Background())
+}
+
+// sealResumedFile seals the active file when it was resumed on open, so the records written before are merged
+// and compressed or encrypted again like the records of the other files.
+func (b *Bitcask) sealResumedFile() error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if b.activeFile.resumedSize == 0 {
+        return nil
+    }
+    return b.createActiveFile()
+}
+
+// mergeFragmented merges the fragmented files like MergeFragmented until ctx is done.
+func (b *Bitcask) mergeFragmented(ctx context.Context) error {
+    if b.config.writePermission == ReadOnly {
+        return BitcaskError(WriteDenied)
+    }
+
+    b.merges.running.Lock()
+    defer b.merges.running.Unlock()
+
+    b.mu.RLock()
+    policy := b.config.mergePolicy
+    b.mu.RUnlock()
+
+    usage, err := b.sealedFileUsage()
+    if err != nil {
+        return err
+    }
+
+    selected := make(map[string]bool)
+    for fileId, fileUsage := range usage {
+        if policy.isFragmented(fileUsage) {
+            selected[fileId] = true
+        }
+    }
+    if len(selected) == 0 {
+        return nil
+    }
+
+    return b.mergeFiles(ctx, selected, len(selected) < len(usage), nil)
+}
+
+// isFragmented reports whether a file reaches a limit of the policy.
+func (p MergePolicy) isFragmented(usage fileUsage) bool {
+    dead := usage.size - usage.live
+    if dead <= 0 {
+        return false
+    }
+    if p.FragMergeTrigger > 0 && dead * 100 >= usage.size * int64(p.FragMergeTrigger) {
+        return true
+    }
+    return p.DeadBytesThreshold > 0 && dead >= p.DeadBytesThreshold
+}
+
+// MergePlan reports the live and dead bytes of every data file, the number of files Merge would write
+// and the space it would save, from the keydir and the file sizes.
+func (b *Bitcask) MergePlan() (MergePlan, error) {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    var plan MergePlan
+    usage, err := b.fileUsage()
+    if err != nil {
+        return plan, err
+    }
+
+    active := MergePlanFile{FileId: b.activeFile.fileName, Active: true, Bytes: int64(b.activeFile.currentSize)}
+    var merged []record
+    for _, recValue := range b.keyDir {
+        lineSize := int64(staticFields * numberFieldSize + recValue.keySize + recValue.valueSize + 1)
+        if recValue.fileId == active.FileId {
+            active.LiveBytes += lineSize
+        } else if _, isExist := usage[recValue.fileId]; isExist {
+            merged = append(merged, recValue)
+        }
+    }
+    active.DeadBytes = active.Bytes - active.LiveBytes
+
+    for fileId, fileUsage := range usage {
+        plan.Files = append(plan.Files, MergePlanFile{
+            FileId:     fileId,
+            Fragmented: b.config.mergePolicy.isFragmented(fileUsage),
+            Bytes:      fileUsage.size,
+            LiveBytes:  fileUsage.live,
+            DeadBytes:  fileUsage.size - fileUsage.live,
+        })
+        plan.SavedBytes += fileUsage.size
+    }
+    sort.Slice(plan.Files, func(i, j int) bool {
+        return compareFileIds(plan.Files[i].FileId, plan.Files[j].FileId) < 0
+    })
+    if b.activeFile.file != nil {
+        plan.Files = append(plan.Files, active)
+    }
+
+    // The records are packed in file order the way Merge starts a new file when a record does not fit.
+    sort.Slice(merged, func(i, j int) bool {
+        if merged[i].fileId != merged[j].fileId {
+            return compareFileIds(merged[i].fileId, merged[j].fileId) < 0
+        }
+        return merged[i].valuePos < merged[j].valuePos
+    })
+    var currentPos int
+    for _, recValue := range merged {
+        lineSize := staticFields * numberFieldSize + recValue.keySize + recValue.valueSize
+        if plan.OutputFiles == 0 || lineSize + currentPos > maxFileSize {
+            plan.OutputFiles++
+            currentPos = 0
+        }
+        currentPos += lineSize + 1
+        plan.SavedBytes -= int64(lineSize + 1)
+    }
+
+    return plan, nil
+}
+
+// sealedFileUsage returns the usage of every sealed data file by file id, taking the read lock.
+func (b *Bitcask) sealedFileUsage() (map[string]fileUsage, error) {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    return b.fileUsage()
+}
+
+// fileUsage returns the usage of every sealed data file by file id, the caller holds the lock.
+func (b *Bitcask) fileUsage() (map[string]fileUsage, error) {
+    files, err := b.fs.ReadDir(b.datastorePath)
+    if err != nil {
+        return nil, err
+    }
+
+    usage := make(map[string]fileUsage)
+    for _, file := range files {
+        if isDataFile(file.Name()) && file.Name() != b.activeFile.fileName {
+            usage[file.Name()] = fileUsage{size: file.Size()}
+        }
+    }
+
+    for _, recValue := range b.keyDir {
+        if fileUsage, isExist := usage[recValue.fileId]; isExist {
+            fileUsage.live += int64(staticFields * numberFieldSize + recValue.keySize + recValue.valueSize + 1)
+            usage[recValue.fileId] = fileUsage
+        }
+    }
+
+    return usage, nil
+}
+
+// mergeFiles rewrites the live records of the selected sealed files and removes them, the caller holds merges.running.
+// keepTombstones carries the last tombstones of the selected files over, for older files that are not merged.
+// The lock is only taken for one record at a time while the records are copied, so Get and Put go on meanwhile.
+// Records replaced during the merge keep their newer version.
+// The merged files are only removed once the files replacing them are synced.
+// When ctx is done the files written so far are removed and the datastore is left as it was.
+// progress, when not nil, is called after every record copied.
+func (b *Bitcask) mergeFiles(ctx context.Context, selected map[string]bool, keepTombstones bool, progress func(Progress)) error {
+    b.mu.Lock()
+    if err := b.sync(); err != nil {
+        b.mu.Unlock()
+        return err
+    }
+    merged := make(map[string]record)
+    for key, recValue := range b.keyDir {
+        if selected[recValue.fileId] {
+            merged[key] = recValue
+        }
+    }
+    b.mu.Unlock()
+
+    w := &mergeWriter{b: b, ctx: ctx, limiter: b.merges.newLimiter()}
+    newRecords := make(map[string]record)
+
+    for key, recValue := range merged {
+        // Merged records keep their sequence number and compressed value so newer writes still win on replay
+        // and compressed values are not expanded, they are encrypted again with the current key.
+        b.mu.RLock()
+        dataRec, err := b.readRecord(key, recValue)
+        b.mu.RUnlock()
+        if err == nil {
+            err = w.limiter.wait(ctx, staticFields * numberFieldSize + recValue.keySize + recValue.valueSize)
+        }
+        if err == nil {
+            dataRec, err = openRecord(b.config.keyring, dataRec)
+        }
+        if err != nil {
+            w.abort()
+            return err
+        }
+        newRecords[key], err = w.add(key, dataRec.value, dataRec.flags &^ mergedFlag, recValue.seq, recValue.tstamp)
+        if err != nil {
+            w.abort()
+            return err
+        }
+        if progress != nil {
+            progress(Progress{Done: len(newRecords), Total: len(merged)})
+        }
+    }
+
+    if keepTombstones {
+        tombstones, err := b.lastTombstones(ctx, selected, w.limiter)
+        if err != nil {
+            w.abort()
+            return err
+        }
+        for _, tombstone := range tombstones {
+            if _, err := w.add(tombstone.key, "", tombstoneFlag, tombstone.seq, tombstone.tstamp); err != nil {
+                w.abort()
+                return err
+            }
+        }
+    }
+
+    if err := w.seal(); err != nil {
+        w.abort()
+        return err
+    }
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    for key, recValue := range newRecords {
+        if current, isExist := b.keyDir[key]; isExist && current == merged[key] {
+            b.keyDir[key] = recValue
+        }
+    }
+    // Mappings of the merged files go away with them once the views into them are released.
+    b.unmapFiles()
+    b.cache.clear()
+
+    // Oldest first and one at a time, so a crash never leaves an older file whose records a tombstone
+    // in a removed newer file deleted.
+    fileIds := make([]string, 0, len(selected))
+    for fileId := range selected {
+        fileIds = append(fileIds, fileId)
+    }
+    sort.Slice(fileIds, func(i, j int) bool {
+        return compareFileIds(fileIds[i], fileIds[j]) < 0
+    })
+    for _, fileId := range fileIds {
+        b.removeMergedFile(fileId)
+        if err := b.fs.SyncDir(b.datastorePath); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// lastTombstones returns the tombstones of the selected files no later write of their key replaced.
+// The keys are decrypted so the tombstones can be sealed again with the current key.
+func (b *Bitcask) lastTombstones(ctx context.Context, selected map[string]bool, limiter *rateLimiter) ([]dataRecord, error) {
+    fileIds := make([]string, 0, len(selected))
+    for fileId := range selected {
+        fileIds = append(fileIds, fileId)
+    }
+    sort.Slice(fileIds, func(i, j int) bool {
+        return compareFileIds(fileIds[i], fileIds[j]) < 0
+    })
+
+    tombstones := make(map[string]dataRecord)
+    for _, fileId := range fileIds {
+        fileData, err := readFile(b.fs, path.Join(b.datastorePath, fileId))
+        if err != nil {
+            return nil, err
+        }
+        if err := limiter.wait(ctx, len(fileData)); err != nil {
+            return nil, err
+        }
+
+        var currentPos int
+        for currentPos < len(fileData) {
+            line, n, err := splitFileLine(fileData[currentPos:])
+            if err != nil {
+                break
+            }
+            currentPos += n
+            dataRec, err := extractFileLine(line)
+            if err != nil {
+                break
+            }
+            if dataRec.flags & tombstoneFlag == 0 {
+                continue
+            }
+
+            key, err := decodeKey(b.config.keyring, dataRec.key, dataRec.flags)
+            if err != nil {
+                return nil, err
+            }
+            if last, isExist := tombstones[key]; !isExist || last.seq < dataRec.seq {
+                tombstones[key] = dataRecord{key: key, seq: dataRec.seq, tstamp: dataRec.tstamp}
+            }
+        }
+    }
+
+    b.mu.RLock()
+    keys := make([]string, 0, len(tombstones))
+    for key, tombstone := range tombstones {
+        if recValue, isExist := b.keyDir[key]; !isExist || recValue.seq < tombstone.seq {
+            keys = append(keys, key)
+        }
+    }
+    b.mu.RUnlock()
+    sort.Strings(keys)
+
+    result := make([]dataRecord, 0, len(keys))
+    for _, key := range keys {
+        result = append(result, tombstones[key])
+    }
+    return result, nil
+}
+
+// add seals and appends a record to the current merge file and returns the keydir record pointing to it.
+func (w *mergeWriter) add(key string, value string, flags int, seq int, tstamp int) (record, error) {
+    storedKey, value, flags, err := w.b.sealRecord(key, value, flags)
+    if err != nil {
+        return record{}, err
+    }
+    fileLine := string(compressFileLine(storedKey, value, seq, tstamp, flags | mergedFlag))
+
+    if w.file != nil && len(fileLine) + w.currentPos > maxFileSize {
+        if err := w.seal(); err != nil {
+            return record{}, err
+        }
+    }
+    if w.file == nil {
+        // The active file moves past the id of every merge file, so appends always go to the newest data file.
+        // An empty active file gives its id to the merge file, which replaces it once sealed.
+        w.b.mu.Lock()
+        if w.b.activeFile.currentSize == 0 {
+            w.fileName = w.b.activeFile.fileName
+        } else {
+            w.fileName, err = w.b.nextFileName()
+        }
+        if err == nil {
+            err = w.b.createActiveFile()
+        }
+        w.b.mu.Unlock()
+        if err != nil {
+            return record{}, err
+        }
+        w.file, err = w.b.fs.OpenFile(path.Join(w.b.datastorePath, mergeFilePrefix + w.fileName), os.O_CREATE | os.O_RDWR)
+        if err != nil {
+            return record{}, err
+        }
+        w.written = append(w.written, w.fileName)
+        w.currentPos = 0
+        w.hintData.Reset()
+    }
+
+    recValue := record{
+        fileId:    w.fileName,
+        valueSize: len(value),
+        valuePos:  w.currentPos + staticFields * numberFieldSize + len(storedKey),
+        keySize:   len(storedKey),
+        seq:       seq,
+        tstamp:    tstamp,
+    }
+
+    n, err := fmt.Fprintln(w.file, fileLine)
+    if err != nil {
+        return record{}, err
+    }
+    w.currentPos += n
+    if err := w.limiter.wait(w.ctx, n); err != nil {
+        return record{}, err
+    }
+
+    hintRec := recValue
+    if flags & tombstoneFlag != 0 {
+        hintRec.valueSize = hintTombstoneSize
+    }
+    fmt.Fprintln(&w.hintData, buildHintFileLine(hintRec, storedKey, flags | mergedFlag))
+
+    return recValue, nil
+}
+
+// seal syncs and closes the current merge file, moves it to its data file name and writes its hint file.
+// The data file and its hint file only appear once the records are synced.
+func (w *mergeWriter) seal() error {
+    if w.file == nil {
+        return nil
+    }
+
+    err := w.file.Sync()
+    if closeErr := w.file.Close(); err == nil {
+        err = closeErr
+    }
+    w.file = nil
+    if err != nil {
+        return err
+    }
+
+    mergePath := path.Join(w.b.datastorePath, mergeFilePrefix + w.fileName)
+    if err := renameSynced(w.b.fs, mergePath, path.Join(w.b.datastorePath, w.fileName)); err != nil {
+        return err
+    }
+    return writeHintData(w.b.fs, w.b.datastorePath, w.fileName, w.hintData.String())
+}
+
+// abort closes and removes the files written by a failed merge, the keydir still points to the merged files.
+// A sealed file left behind repeats records that are still in place, so it is harmless on the next open.
+func (w *mergeWriter) abort() {
+    if w.file != nil {
+        w.file.Close()
+        w.file = nil
+    }
+    for _, fileName := range w.written {
+        w.b.fs.Remove(path.Join(w.b.datastorePath, mergeFilePrefix + fileName))
+        w.b.fs.Remove(path.Join(w.b.datastorePath, fileName))
+        w.b.fs.Remove(path.Join(w.b.datastorePath, hintFilePrefix + fileName))
+    }
+    w.written = nil
+}
+
+// removeMergeFiles removes the unsealed merge files of a process that stopped during a merge.
+func (b *Bitcask) removeMergeFiles() {
+    files, _ := b.fs.ReadDir(b.datastorePath)
+    for _, file := range files {
+        if strings.HasPrefix(file.Name(), mergeFilePrefix) {
+            b.fs.Remove(path.Join(b.datastorePath, file.Name()))
+        }
+    }
+}
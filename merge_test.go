@@ -0,0 +1,236 @@
+package bitcask
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// removeOnceFS is a FileSystem in memory that fails to remove a data file once one was removed while limit is set.
+type removeOnceFS struct {
+    FileSystem
+    limit bool
+    removed bool
+}
+
+func (r *removeOnceFS) Remove(name string) error {
+    if r.limit && isDataFile(path.Base(name)) {
+        if r.removed {
+            return errors.New("process stopped")
+        }
+        r.removed = true
+    }
+    return r.FileSystem.Remove(name)
+}
+
+func TestMergeFiles(t *testing.T) {
+    t.Run("only the given files are merged", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        first := b.activeFile.fileName
+        b.Put("key1", "value1")
+        fillActiveFile(b)
+        second := b.activeFile.fileName
+        fillActiveFile(b)
+
+        before, _ := os.Stat(path.Join(testBitcaskPath, second))
+        if err := b.MergeFiles([]string{first}); err != nil {
+            t.Fatal(err)
+        }
+
+        dataFiles, _ := listDataFiles(testBitcaskPath)
+        if dataFiles[first] {
+            t.Errorf("expected merged file %s to be removed", first)
+        }
+        after, err := os.Stat(path.Join(testBitcaskPath, second))
+        if err != nil || after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+            t.Errorf("expected file %s to be left untouched", second)
+        }
+        got, _ := b.Get("key1")
+        assertString(t, got, "value1")
+        b.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        got, _ = b.Get("key1")
+        assertString(t, got, "value1")
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("tombstones survive a partial merge", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        fillActiveFile(b)
+        deleted := b.activeFile.fileName
+        b.Delete("key1")
+        fillActiveFile(b)
+
+        if err := b.MergeFiles([]string{deleted}); err != nil {
+            t.Fatal(err)
+        }
+        b.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        _, err := b.Get("key1")
+        assertError(t, err, "key1: " + KeyDoesNotExist)
+
+        b.Merge()
+        b.Close()
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        _, err = b.Get("key1")
+        assertError(t, err, "key1: " + KeyDoesNotExist)
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("crash while removing merged files keeps deleted keys deleted", func(t *testing.T) {
+        // The files are removed in random order without the fix, so the crash is tried a few times.
+        for i := 0; i < 10; i++ {
+            fsys := &removeOnceFS{FileSystem: NewMemFileSystem()}
+            b, _ := OpenFS(fsys, testBitcaskPath, nil, ReadWrite)
+            b.Put("key1", "value1")
+            fillActiveFile(b)
+            b.Delete("key1")
+            fillActiveFile(b)
+
+            // The process stops after the first merged data file is removed.
+            fsys.limit = true
+            b.Merge()
+            files, _ := fsys.FileSystem.ReadDir(testBitcaskPath)
+            for _, file := range files {
+                if strings.HasPrefix(file.Name(), writeLock) {
+                    fsys.FileSystem.Remove(path.Join(testBitcaskPath, file.Name()))
+                }
+            }
+
+            b, err := OpenFS(fsys.FileSystem, testBitcaskPath, nil, ReadWrite)
+            if err != nil {
+                t.Fatal(err)
+            }
+            _, err = b.Get("key1")
+            assertError(t, err, "key1: " + KeyDoesNotExist)
+            b.Close()
+        }
+    })
+
+    t.Run("only sealed files can be merged", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+
+        err := b.MergeFiles([]string{b.activeFile.fileName})
+        assertError(t, err, b.activeFile.fileName + ": " + NotASealedFile)
+        err = b.MergeFiles([]string{"404"})
+        assertError(t, err, "404: " + NotASealedFile)
+        b.Close()
+
+        r, _ := Open(testBitcaskPath)
+        assertError(t, r.MergeFiles(nil), WriteDenied)
+        assertError(t, r.MergeFragmented(), WriteDenied)
+        r.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
+
+func TestMergeFragmented(t *testing.T) {
+    t.Run("compact files are left untouched", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        fragmented := b.activeFile.fileName
+        b.Put("key1", "value1")
+        fillActiveFile(b)
+        // The fill keys written again leave the first file mostly dead.
+        compact := b.activeFile.fileName
+        fillActiveFile(b)
+
+        if err := b.MergeFragmented(); err != nil {
+            t.Fatal(err)
+        }
+        dataFiles, _ := listDataFiles(testBitcaskPath)
+        if dataFiles[fragmented] {
+            t.Errorf("expected fragmented file %s to be merged", fragmented)
+        }
+        if !dataFiles[compact] {
+            t.Errorf("expected compact file %s to be left untouched", compact)
+        }
+        got, _ := b.Get("key1")
+        assertString(t, got, "value1")
+
+        // A policy nothing reaches writes nothing.
+        b.SetMergePolicy(MergePolicy{FragMergeTrigger: 100})
+        before, _ := listDataFiles(testBitcaskPath)
+        if err := b.MergeFragmented(); err != nil {
+            t.Fatal(err)
+        }
+        after, _ := listDataFiles(testBitcaskPath)
+        if len(after) != len(before) {
+            t.Errorf("got %d data files, want %d", len(after), len(before))
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
+
+func TestMergePlan(t *testing.T) {
+    t.Run("plan matches the merge", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        fragmented := b.activeFile.fileName
+        b.Put("key1", "value1")
+        fillActiveFile(b)
+        fillActiveFile(b)
+        b.Put("key2", "value2")
+
+        before, _ := os.ReadDir(testBitcaskPath)
+        plan, err := b.MergePlan()
+        if err != nil {
+            t.Fatal(err)
+        }
+        after, _ := os.ReadDir(testBitcaskPath)
+        if len(after) != len(before) {
+            t.Errorf("expected the plan to write nothing")
+        }
+
+        dataFiles, _ := listDataFiles(testBitcaskPath)
+        if len(plan.Files) != len(dataFiles) {
+            t.Fatalf("got %d files in the plan, want %d", len(plan.Files), len(dataFiles))
+        }
+        var sealedBytes int64
+        for _, file := range plan.Files {
+            if file.LiveBytes + file.DeadBytes != file.Bytes {
+                t.Errorf("%s: live %d and dead %d bytes do not add up to %d", file.FileId, file.LiveBytes, file.DeadBytes, file.Bytes)
+            }
+            if file.Fragmented != (file.FileId == fragmented) {
+                t.Errorf("%s: got fragmented %v", file.FileId, file.Fragmented)
+            }
+            if !file.Active {
+                sealedBytes += file.Bytes
+            }
+        }
+        if last := plan.Files[len(plan.Files) - 1]; !last.Active || last.FileId != b.activeFile.fileName {
+            t.Errorf("expected the active file last, got %+v", last)
+        }
+
+        active := b.activeFile.fileName
+        b.Merge()
+        var outputBytes int64
+        var outputFiles int
+        files, _ := os.ReadDir(testBitcaskPath)
+        for _, file := range files {
+            info, _ := file.Info()
+            if isDataFile(file.Name()) && !dataFiles[file.Name()] && file.Name() != b.activeFile.fileName {
+                outputFiles++
+                outputBytes += info.Size()
+            }
+        }
+        if outputFiles != plan.OutputFiles {
+            t.Errorf("got %d merge files, plan expected %d", outputFiles, plan.OutputFiles)
+        }
+        if saved := sealedBytes - outputBytes; saved != plan.SavedBytes {
+            t.Errorf("merge saved %d bytes, plan expected %d", saved, plan.SavedBytes)
+        }
+        if dataFiles, _ := listDataFiles(testBitcaskPath); !dataFiles[active] {
+            t.Errorf("expected the active file %s to be kept", active)
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
@@ -0,0 +1,164 @@
+package bitcask
+
+import (
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path"
+	"sync"
+)
+
+const (
+    // MmapFiles makes the bitcask map sealed data files in memory and read values out of the mappings.
+    MmapFiles ConfigOpt = 6
+
+    // Error message when memory mapping files is not possible on the platform.
+    MmapNotSupported = "mmap is not supported on this platform"
+)
+
+// mappedFiles holds the memory mappings of sealed data files by file id.
+type mappedFiles struct {
+    mu sync.Mutex
+    data map[string]*fileMapping
+}
+
+// fileMapping is the memory mapping of a data file and the number of views still using it.
+type fileMapping struct {
+    data []byte
+    views int
+    // Set once the mapping was released while views still used it, the last view unmaps it.
+    released bool
+}
+
+// GetView retrieves the value by key like Get without copying it out of the file mapping when MmapFiles is set.
+// The returned slice must not be modified and stays valid until release is called,
+// merges and Close unmap a file only once every view of it is released.
+// Values in the active file, compressed or encrypted values and values of an unmapped datastore are copied.
+// returns an error if key does not exist in the bitcask datastore.
+func (b *Bitcask) GetView(key string) ([]byte, func(), error) {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    rec, isExist := b.keyDir[key]
+    if !isExist {
+        return nil, nil, BitcaskError(fmt.Sprintf("%s: %s", key, KeyDoesNotExist))
+    }
+
+    line := b.mappedLine(rec)
+    if line != nil {
+        header := staticFields * numberFieldSize
+        dataRec, crc, err := extractFileLineHeader(string(line[:header]))
+        if err != nil || dataRec.keySize != rec.keySize || dataRec.valueSize != rec.valueSize ||
+        crc != crc32.ChecksumIEEE(line[numberFieldSize:]) {
+            return nil, nil, BitcaskError(fmt.Sprintf("%s: %s", key, CorruptedRecord))
+        }
+        if dataRec.flags &^ mergedFlag == 0 {
+            return line[header + rec.keySize:], b.holdMapping(rec.fileId), nil
+        }
+    }
+
+    value, err := b.get(key)
+    if err != nil {
+        return nil, nil, err
+    }
+    return []byte(value), func() {}, nil
+}
+
+// holdMapping counts a view into the mapping of fileId and returns the func releasing it, the caller holds the lock.
+func (b *Bitcask) holdMapping(fileId string) func() {
+    b.mapped.mu.Lock()
+    defer b.mapped.mu.Unlock()
+
+    m := b.mapped.data[fileId]
+    m.views++
+
+    var once sync.Once
+    return func() {
+        once.Do(func() {
+            b.mapped.mu.Lock()
+            defer b.mapped.mu.Unlock()
+
+            m.views--
+            if m.views == 0 && m.released {
+                munmapFile(m.data)
+            }
+        })
+    }
+}
+
+// mappedLine returns the file line a keydir record points to out of its file mapping,
+// nil if the file is not mapped or the mapping does not cover the line.
+func (b *Bitcask) mappedLine(rec record) []byte {
+    data := b.mapping(rec.fileId)
+    linePos := rec.valuePos - staticFields * numberFieldSize - rec.keySize
+    end := rec.valuePos + rec.valueSize
+    if data == nil || linePos < 0 || end > len(data) {
+        return nil
+    }
+    return data[linePos:end:end]
+}
+
+// mapping returns the mapping of a sealed data file, the file is mapped on first use.
+// Of an active file resumed on open only the records written before are mapped, since they are not written again.
+// returns nil if MmapFiles is not set or the file cannot be mapped, so the caller reads the file instead.
+// Only files of OSFileSystem can be mapped.
+func (b *Bitcask) mapping(fileId string) []byte {
+    if !b.config.mmap || (fileId == b.activeFile.fileName && b.activeFile.resumedSize == 0) {
+        return nil
+    }
+
+    b.mapped.mu.Lock()
+    defer b.mapped.mu.Unlock()
+
+    if m, isExist := b.mapped.data[fileId]; isExist {
+        return m.data
+    }
+
+    file, err := b.fs.Open(path.Join(b.datastorePath, fileId))
+    if err != nil {
+        return nil
+    }
+    defer file.Close()
+    osFile, isOSFile := file.(*os.File)
+    if !isOSFile {
+        return nil
+    }
+
+    size := b.activeFile.resumedSize
+    if fileId != b.activeFile.fileName {
+        info, err := file.Stat()
+        if err != nil {
+            return nil
+        }
+        size = int(info.Size())
+    }
+    if size == 0 {
+        return nil
+    }
+    data, err := mmapFile(osFile, size)
+    if err != nil {
+        return nil
+    }
+
+    if b.mapped.data == nil {
+        b.mapped.data = make(map[string]*fileMapping)
+    }
+    b.mapped.data[fileId] = &fileMapping{data: data}
+    return data
+}
+
+// unmapFiles releases all file mappings, the caller holds the write lock so no Get is reading them.
+// A mapping views still use is unmapped once the last of them is released.
+func (b *Bitcask) unmapFiles() {
+    b.mapped.mu.Lock()
+    defer b.mapped.mu.Unlock()
+
+    for fileId, m := range b.mapped.data {
+        if m.views == 0 {
+            munmapFile(m.data)
+        } else {
+            m.released = true
+        }
+        delete(b.mapped.data, fileId)
+    }
+}
@@ -0,0 +1,17 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package bitcask
+
+import (
+	"os"
+)
+
+// mmapFile always fails on platforms without mmap, so files are read with ReadAt.
+func mmapFile(file *os.File, size int) ([]byte, error) {
+    return nil, BitcaskError(MmapNotSupported)
+}
+
+// munmapFile has nothing to release on platforms without mmap.
+func munmapFile(data []byte) error {
+    return nil
+}
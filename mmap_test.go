@@ -0,0 +1,94 @@
+package bitcask
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMmap(t *testing.T) {
+    t.Run("values of sealed files are read from mappings", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        b.Put("key2", "value2")
+        b.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite, MmapFiles)
+        b.Put("key3", "value3")
+
+        got, err := b.Get("key1")
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, got, "value1")
+        if len(b.mapped.data) != 1 {
+            t.Errorf("expected the sealed file to be mapped, got %d mappings", len(b.mapped.data))
+        }
+
+        view, release, _ := b.GetView("key2")
+        assertString(t, string(view), "value2")
+        release()
+        view, release, _ = b.GetView("key3")
+        assertString(t, string(view), "value3")
+        release()
+
+        b.Merge()
+        if len(b.mapped.data) != 0 {
+            t.Errorf("expected merge to release mappings, got %d", len(b.mapped.data))
+        }
+        view, release, _ = b.GetView("key1")
+        assertString(t, string(view), "value1")
+        release()
+
+        b.Close()
+        if len(b.mapped.data) != 0 {
+            t.Errorf("expected close to release mappings, got %d", len(b.mapped.data))
+        }
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("view stays valid across merge and close until released", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        b.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite, MmapFiles)
+        b.Put("key2", "value2")
+        view, release, err := b.GetView("key1")
+        if err != nil {
+            t.Fatal(err)
+        }
+        // A background merge may unmap the file any time.
+        b.Merge()
+        assertString(t, string(view), "value1")
+        b.Close()
+        assertString(t, string(view), "value1")
+        release()
+        release()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("view of a compressed value is decoded", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite, CompressValues)
+        value := string(make([]byte, 1000))
+        b.Put("key1", value)
+        b.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite, MmapFiles)
+        view, release, err := b.GetView("key1")
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, string(view), value)
+        release()
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("get view of missing key", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite, MmapFiles)
+        _, _, err := b.GetView("unknown")
+        assertError(t, err, "unknown: " + KeyDoesNotExist)
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
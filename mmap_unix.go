@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package bitcask
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the first size bytes of a file read only.
+func mmapFile(file *os.File, size int) ([]byte, error) {
+    return syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmapFile releases a mapping made by mmapFile.
+func munmapFile(data []byte) error {
+    return syscall.Munmap(data)
+}
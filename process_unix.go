@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package bitcask
+
+import (
+	"syscall"
+)
+
+// processAlive reports whether a process with the given pid is running.
+func processAlive(pid int) bool {
+    if pid <= 0 {
+        return false
+    }
+    err := syscall.Kill(pid, 0)
+    return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,465 @@
+package bitcask
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+    // Error message when a follower has data its leader does not have.
+    ReplicaDiverged = "follower diverged from leader"
+)
+
+// Default maximum number of bytes shipped in one replication chunk 1MB.
+const defaultChunkSize = 1024 * 1024
+
+// ReplicationChunk carries whole records appended to a leader data file after a given offset.
+type ReplicationChunk struct {
+    FileId string
+    Offset int
+    Data []byte
+    // LeaderFiles maps every data file of the leader to its size.
+    LeaderFiles map[string]int
+}
+
+// ReplicationSource gives a follower the data it is missing from its leader.
+// have maps the follower data files to their sizes.
+// An empty chunk means the follower has caught up.
+type ReplicationSource interface {
+    FetchReplication(have map[string]int, maxBytes int) (ReplicationChunk, error)
+}
+
+// ReplicationLag describes how far a follower is behind its leader.
+type ReplicationLag struct {
+    // Bytes the leader had at the last pull that the follower does not have yet.
+    Bytes int
+    // Time of the last pull that caught up with the leader.
+    CaughtUpAt time.Time
+}
+
+// Follower replicates a leader bitcask datastore into a local directory.
+// Its store serves reads, writes are denied since they only come from the leader.
+type Follower struct {
+    store *Bitcask
+    source ReplicationSource
+    chunkSize int
+
+    mu sync.Mutex
+    lag ReplicationLag
+}
+
+// replicationRequest is sent by a follower over a replication connection.
+type replicationRequest struct {
+    Have map[string]int
+    MaxBytes int
+}
+
+// replicationResponse is sent by a leader over a replication connection.
+type replicationResponse struct {
+    Chunk ReplicationChunk
+    Err string
+}
+
+// ReplicationClient fetches replication chunks from a leader over TCP.
+type ReplicationClient struct {
+    mu sync.Mutex
+    conn net.Conn
+    encoder *gob.Encoder
+    decoder *gob.Decoder
+}
+
+// FetchReplication returns the first records the follower is missing, oldest data file first.
+// The chunk holds whole records only and is at most maxBytes long unless a single record is larger.
+func (b *Bitcask) FetchReplication(have map[string]int, maxBytes int) (ReplicationChunk, error) {
+    chunk := ReplicationChunk{LeaderFiles: make(map[string]int)}
+
+    // Buffered records are written out so followers get them.
+    b.mu.Lock()
+    err := b.flush()
+    b.mu.Unlock()
+    if err != nil {
+        return chunk, err
+    }
+
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    files, err := b.fs.ReadDir(b.datastorePath)
+    if err != nil {
+        return chunk, err
+    }
+
+    var names []string
+    for _, file := range files {
+        if !isDataFile(file.Name()) {
+            continue
+        }
+        names = append(names, file.Name())
+        chunk.LeaderFiles[file.Name()] = int(file.Size())
+    }
+    sort.Slice(names, func(i, j int) bool {
+        return compareFileIds(names[i], names[j]) < 0
+    })
+
+    for _, name := range names {
+        size, offset := chunk.LeaderFiles[name], have[name]
+        if offset > size {
+            return chunk, BitcaskError(fmt.Sprintf("%s: %s", name, ReplicaDiverged))
+        }
+        if offset == size {
+            continue
+        }
+
+        data, err := readWholeRecords(b.fs, path.Join(b.datastorePath, name), offset, size, maxBytes)
+        if err != nil {
+            return chunk, err
+        }
+        chunk.FileId = name
+        chunk.Offset = offset
+        chunk.Data = data
+        break
+    }
+
+    return chunk, nil
+}
+
+// readWholeRecords reads the records of a data file on fsys between offset and size,
+// stopping before the record that would make the data longer than maxBytes.
+func readWholeRecords(fsys FileSystem, filePath string, offset int, size int, maxBytes int) ([]byte, error) {
+    file, err := fsys.Open(filePath)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    limit := size - offset
+    if maxBytes > 0 && limit > maxBytes {
+        limit = maxBytes
+    }
+
+    for {
+        data := make([]byte, limit)
+        if _, err := file.ReadAt(data, int64(offset)); err != nil && err != io.EOF {
+            return nil, err
+        }
+
+        currentPos := 0
+        for currentPos < len(data) {
+            _, n, err := splitFileLine(data[currentPos:])
+            if err != nil {
+                break
+            }
+            currentPos += n
+        }
+
+        // A single record larger than maxBytes is sent alone.
+        if currentPos == 0 && limit < size - offset {
+            limit = size - offset
+            continue
+        }
+        return data[:currentPos], nil
+    }
+}
+
+// ServeReplication answers replication requests of followers connecting to the listener
+// until the listener is closed.
+func (b *Bitcask) ServeReplication(l net.Listener) error {
+    for {
+        conn, err := l.Accept()
+        if err != nil {
+            if errors.Is(err, net.ErrClosed) {
+                return nil
+            }
+            return err
+        }
+
+        go func() {
+            defer conn.Close()
+            decoder := gob.NewDecoder(conn)
+            encoder := gob.NewEncoder(conn)
+
+            for {
+                var req replicationRequest
+                if err := decoder.Decode(&req); err != nil {
+                    return
+                }
+
+                var res replicationResponse
+                chunk, fetchErr := b.FetchReplication(req.Have, req.MaxBytes)
+                res.Chunk = chunk
+                if fetchErr != nil {
+                    res.Err = fetchErr.Error()
+                }
+                if err := encoder.Encode(res); err != nil {
+                    return
+                }
+            }
+        }()
+    }
+}
+
+// DialReplication connects to a leader serving replication on a TCP address.
+func DialReplication(addr string) (*ReplicationClient, error) {
+    conn, err := net.Dial("tcp", addr)
+    if err != nil {
+        return nil, err
+    }
+
+    return &ReplicationClient{
+        conn:    conn,
+        encoder: gob.NewEncoder(conn),
+        decoder: gob.NewDecoder(conn),
+    }, nil
+}
+
+// FetchReplication asks the leader for the data the follower is missing.
+func (c *ReplicationClient) FetchReplication(have map[string]int, maxBytes int) (ReplicationChunk, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if err := c.encoder.Encode(replicationRequest{Have: have, MaxBytes: maxBytes}); err != nil {
+        return ReplicationChunk{}, err
+    }
+
+    var res replicationResponse
+    if err := c.decoder.Decode(&res); err != nil {
+        return ReplicationChunk{}, err
+    }
+    if res.Err != "" {
+        return res.Chunk, BitcaskError(res.Err)
+    }
+    return res.Chunk, nil
+}
+
+// Close closes the connection to the leader.
+func (c *ReplicationClient) Close() error {
+    return c.conn.Close()
+}
+
+// OpenFollower opens or creates a follower datastore in dirPath replicating from source.
+// Damaged tails left by an interrupted pull are truncated before replication resumes.
+// Once closed the directory can be opened with Open, for example to promote it to a leader.
+func OpenFollower(dirPath string, source ReplicationSource) (*Follower, error) {
+    return OpenEncryptedFollower(dirPath, source, nil)
+}
+
+// OpenEncryptedFollower opens a follower like OpenFollower for a leader encrypted with the keys of keyring.
+// Records are shipped as they are stored, so they stay encrypted on the follower.
+func OpenEncryptedFollower(dirPath string, source ReplicationSource, keyring *Keyring) (*Follower, error) {
+    store := &Bitcask{
+        fs:            OSFileSystem,
+        keyDir:        make(map[string]record),
+        datastorePath: dirPath,
+        config:        options{writePermission: ReadOnly, syncOption: SyncOnDemand, keyring: keyring},
+    }
+
+    if err := store.fs.MkdirAll(dirPath); err != nil {
+        return nil, BitcaskError(fmt.Sprintf("%s: %s", dirPath, CannotOpenThisDir))
+    }
+
+    if store.lockCheck() != noProcess {
+        return nil, BitcaskError(WriterExist)
+    }
+    // Records are shipped as they are stored, so the follower has the format of the leader.
+    if err := checkFormat(store.fs, dirPath, true); err != nil {
+        return nil, err
+    }
+
+    files, err := store.fs.ReadDir(dirPath)
+    if err != nil {
+        return nil, BitcaskError(fmt.Sprintf("%s: %s", dirPath, CannotOpenThisDir))
+    }
+
+    var names []string
+    for _, file := range files {
+        if isDataFile(file.Name()) {
+            names = append(names, file.Name())
+        }
+    }
+    sort.Slice(names, func(i, j int) bool {
+        return compareFileIds(names[i], names[j]) < 0
+    })
+
+    store.tombstones = make(map[string]record)
+    for _, name := range names {
+        fileData, err := readFile(store.fs, path.Join(dirPath, name))
+        if err != nil {
+            return nil, err
+        }
+        validSize, err := store.replayFileData(name, 0, fileData)
+        if err != nil {
+            return nil, err
+        }
+        if validSize < len(fileData) {
+            if err := truncateFile(store.fs, path.Join(dirPath, name), int64(validSize)); err != nil {
+                return nil, err
+            }
+        }
+    }
+
+    store.lock = uniqueName(writeLock)
+    if err := store.fs.Lock(path.Join(dirPath, store.lock)); err != nil {
+        return nil, err
+    }
+
+    return &Follower{store: store, source: source, chunkSize: defaultChunkSize}, nil
+}
+
+// Store returns the read only datastore kept up to date by the follower.
+func (f *Follower) Store() *Bitcask {
+    return f.store
+}
+
+// Pull fetches and applies chunks from the leader until the follower has caught up,
+// then removes the data files the leader no longer has.
+func (f *Follower) Pull() error {
+    for {
+        have, err := f.localFiles()
+        if err != nil {
+            return err
+        }
+
+        chunk, err := f.source.FetchReplication(have, f.chunkSize)
+        if err != nil {
+            return err
+        }
+
+        lag := 0
+        for name, size := range chunk.LeaderFiles {
+            if size > have[name] {
+                lag += size - have[name]
+            }
+        }
+        f.mu.Lock()
+        f.lag.Bytes = lag
+        f.mu.Unlock()
+
+        if len(chunk.Data) == 0 {
+            if err := f.removeStaleFiles(have, chunk.LeaderFiles); err != nil {
+                return err
+            }
+            f.mu.Lock()
+            f.lag.CaughtUpAt = time.Now()
+            f.mu.Unlock()
+            return nil
+        }
+
+        if err := f.apply(chunk, have[chunk.FileId]); err != nil {
+            return err
+        }
+    }
+}
+
+// Run pulls from the leader every interval until stop is closed or a pull fails.
+func (f *Follower) Run(interval time.Duration, stop <-chan struct{}) error {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        if err := f.Pull(); err != nil {
+            return err
+        }
+
+        select {
+        case <-stop:
+            return nil
+        case <-ticker.C:
+        }
+    }
+}
+
+// Lag reports how far the follower was behind the leader at the last pull.
+func (f *Follower) Lag() ReplicationLag {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    return f.lag
+}
+
+// Close releases the follower datastore.
+func (f *Follower) Close() {
+    f.store.Close()
+}
+
+// apply appends a chunk to its local data file and points the keydir to its records.
+func (f *Follower) apply(chunk ReplicationChunk, localSize int) error {
+    if chunk.Offset != localSize {
+        return BitcaskError(fmt.Sprintf("%s: %s", chunk.FileId, ReplicaDiverged))
+    }
+
+    file, err := f.store.fs.OpenFile(path.Join(f.store.datastorePath, chunk.FileId),
+    os.O_CREATE | os.O_WRONLY | os.O_APPEND)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    if _, err := file.Write(chunk.Data); err != nil {
+        return err
+    }
+    if err := file.Sync(); err != nil {
+        return err
+    }
+
+    f.store.mu.Lock()
+    defer f.store.mu.Unlock()
+
+    _, err = f.store.replayFileData(chunk.FileId, chunk.Offset, chunk.Data)
+    return err
+}
+
+// removeStaleFiles removes the local data files merged away on the leader.
+func (f *Follower) removeStaleFiles(have map[string]int, leaderFiles map[string]int) error {
+    f.store.mu.Lock()
+    defer f.store.mu.Unlock()
+
+    for name := range have {
+        if _, isExist := leaderFiles[name]; isExist {
+            continue
+        }
+        // The leader merged this file so its live records were shipped again in newer files.
+        // Older copies of the keys it deleted went with it, tombstones still needed were shipped again too.
+        for key, recValue := range f.store.keyDir {
+            if recValue.fileId == name {
+                delete(f.store.keyDir, key)
+            }
+        }
+        for key, recValue := range f.store.tombstones {
+            if recValue.fileId == name {
+                delete(f.store.tombstones, key)
+            }
+        }
+        if err := f.store.fs.Remove(path.Join(f.store.datastorePath, name)); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// localFiles maps the follower data files to their sizes.
+func (f *Follower) localFiles() (map[string]int, error) {
+    have := make(map[string]int)
+
+    files, err := f.store.fs.ReadDir(f.store.datastorePath)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, file := range files {
+        if !isDataFile(file.Name()) {
+            continue
+        }
+        have[file.Name()] = int(file.Size())
+    }
+
+    return have, nil
+}
@@ -0,0 +1,187 @@
+package bitcask
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"testing"
+)
+
+var testFollowerPath = path.Join("testing_follower_dir")
+
+func TestReplication(t *testing.T) {
+    t.Run("follower catches up with puts, deletes and merges", func(t *testing.T) {
+        leader, _ := Open(testBitcaskPath, ReadWrite)
+        follower, err := OpenFollower(testFollowerPath, leader)
+        if err != nil {
+            t.Fatal(err)
+        }
+        follower.chunkSize = 1024
+
+        for i := 0; i < 500; i++ {
+            leader.Put(fmt.Sprintf("key%d", i + 1), fmt.Sprintf("value%d", i + 1))
+        }
+        leader.Delete("key1")
+        follower.Pull()
+
+        got, _ := follower.Store().Get("key500")
+        assertString(t, got, "value500")
+        _, err = follower.Store().Get("key1")
+        assertError(t, err, "key1: key does not exist")
+
+        leader.Merge()
+        leader.Put("key2", "changed")
+        follower.Pull()
+
+        got, _ = follower.Store().Get("key2")
+        assertString(t, got, "changed")
+        got, _ = follower.Store().Get("key250")
+        assertString(t, got, "value250")
+        assertFilesEqual(t, testBitcaskPath, testFollowerPath)
+
+        if lag := follower.Lag(); lag.Bytes != 0 || lag.CaughtUpAt.IsZero() {
+            t.Errorf("expected no lag, got: %+v", lag)
+        }
+
+        leader.Close()
+        follower.Close()
+        os.RemoveAll(testBitcaskPath)
+        os.RemoveAll(testFollowerPath)
+    })
+
+    t.Run("follower forgets tombstones it no longer needs", func(t *testing.T) {
+        leader, _ := Open(testBitcaskPath, ReadWrite)
+        follower, _ := OpenFollower(testFollowerPath, leader)
+        leader.Put("key1", "value1")
+        leader.Put("key2", "value2")
+        leader.Delete("key1")
+        leader.Delete("key2")
+        follower.Pull()
+        if len(follower.store.tombstones) != 2 {
+            t.Errorf("got tombstones %v, want key1 and key2", follower.store.tombstones)
+        }
+
+        leader.Put("key1", "changed")
+        follower.Pull()
+        if _, isExist := follower.store.tombstones["key1"]; isExist || len(follower.store.tombstones) != 1 {
+            t.Errorf("got tombstones %v, want only key2 once key1 is written again", follower.store.tombstones)
+        }
+
+        fillActiveFile(leader)
+        leader.Merge()
+        follower.Pull()
+        if len(follower.store.tombstones) != 0 {
+            t.Errorf("got tombstones %v, want none once their file is merged away", follower.store.tombstones)
+        }
+        got, _ := follower.Store().Get("key1")
+        assertString(t, got, "changed")
+        _, err := follower.Store().Get("key2")
+        assertError(t, err, "key2: " + KeyDoesNotExist)
+
+        leader.Close()
+        follower.Close()
+        os.RemoveAll(testBitcaskPath)
+        os.RemoveAll(testFollowerPath)
+    })
+
+    t.Run("follower store denies writes", func(t *testing.T) {
+        leader, _ := Open(testBitcaskPath, ReadWrite)
+        follower, _ := OpenFollower(testFollowerPath, leader)
+
+        err := follower.Store().Put("key1", "value1")
+        assertError(t, err, "write permission denied")
+
+        leader.Close()
+        follower.Close()
+        os.RemoveAll(testBitcaskPath)
+        os.RemoveAll(testFollowerPath)
+    })
+
+    t.Run("reopened follower resumes and can be promoted", func(t *testing.T) {
+        leader, _ := Open(testBitcaskPath, ReadWrite)
+        leader.Put("key1", "value1")
+
+        follower, _ := OpenFollower(testFollowerPath, leader)
+        follower.Pull()
+        follower.Close()
+
+        leader.Put("key2", "value2")
+        follower, _ = OpenFollower(testFollowerPath, leader)
+        follower.Pull()
+        follower.Close()
+        leader.Close()
+
+        promoted, err := Open(testFollowerPath, ReadWrite)
+        if err != nil {
+            t.Fatal(err)
+        }
+        got, _ := promoted.Get("key2")
+        promoted.Close()
+
+        assertString(t, got, "value2")
+        os.RemoveAll(testBitcaskPath)
+        os.RemoveAll(testFollowerPath)
+    })
+
+    t.Run("replication over tcp reports lag", func(t *testing.T) {
+        leader, _ := Open(testBitcaskPath, ReadWrite)
+        l, _ := net.Listen("tcp", "127.0.0.1:0")
+        go leader.ServeReplication(l)
+
+        client, err := DialReplication(l.Addr().String())
+        if err != nil {
+            t.Fatal(err)
+        }
+        follower, _ := OpenFollower(testFollowerPath, client)
+
+        leader.Put("key1", "value1")
+        chunk, _ := client.FetchReplication(map[string]int{}, 0)
+        if len(chunk.Data) == 0 {
+            t.Fatal("expected the follower to be behind")
+        }
+
+        follower.Pull()
+        got, _ := follower.Store().Get("key1")
+        assertString(t, got, "value1")
+
+        client.Close()
+        l.Close()
+        leader.Close()
+        follower.Close()
+        os.RemoveAll(testBitcaskPath)
+        os.RemoveAll(testFollowerPath)
+    })
+}
+
+// assertFilesEqual checks both directories have the same data files with the same content.
+func assertFilesEqual(t testing.TB, dir1 string, dir2 string) {
+    t.Helper()
+    files1, _ := listDataFiles(dir1)
+    files2, _ := listDataFiles(dir2)
+    if len(files1) != len(files2) {
+        t.Fatalf("got %d data files, want %d", len(files2), len(files1))
+    }
+    for name := range files1 {
+        data1, _ := os.ReadFile(path.Join(dir1, name))
+        data2, err := os.ReadFile(path.Join(dir2, name))
+        if err != nil || string(data1) != string(data2) {
+            t.Errorf("data file %s differs", name)
+        }
+    }
+}
+
+// listDataFiles lists the data file names of a directory.
+func listDataFiles(dir string) (map[string]bool, error) {
+    names := make(map[string]bool)
+    files, err := os.ReadDir(dir)
+    if err != nil {
+        return nil, err
+    }
+    for _, file := range files {
+        if isDataFile(file.Name()) {
+            names[file.Name()] = true
+        }
+    }
+    return names, nil
+}
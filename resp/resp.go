@@ -0,0 +1,450 @@
+// Package resp serves a bitcask datastore over a subset of the Redis RESP protocol.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"bitcask"
+)
+
+// Maximum size of a bulk string accepted from a client 64MB.
+const maxBulkSize = 64 * 1024 * 1024
+
+// Maximum size of all the bulk strings of a request together 128MB.
+const maxRequestSize = 2 * maxBulkSize
+
+// Maximum length of a request line, inline commands included, 64KB like redis.
+const maxLineSize = 64 * 1024
+
+// Default number of keys returned by a SCAN call.
+const defaultScanCount = 10
+
+// Number of SCAN cursors kept, the oldest is forgotten past it.
+const maxScanCursors = 4096
+
+// Server answers GET, SET, DEL, EXISTS, KEYS, SCAN, PING, DBSIZE and QUIT commands
+// from redis clients using a bitcask datastore.
+type Server struct {
+    store *bitcask.Bitcask
+
+    mu sync.Mutex
+    listener net.Listener
+    conns map[net.Conn]struct{}
+    closed bool
+    // Last key returned under every SCAN cursor kept.
+    cursors map[int]string
+    lastCursor int
+}
+
+// errProtocol is returned when a client sends a request that is not valid RESP.
+var errProtocol = errors.New("protocol error")
+
+// New creates a server for the given bitcask datastore.
+func New(store *bitcask.Bitcask) *Server {
+    return &Server{store: store, conns: make(map[net.Conn]struct{}), cursors: make(map[int]string)}
+}
+
+// Serve accepts connections on the listener until Close is called.
+func (s *Server) Serve(l net.Listener) error {
+    s.mu.Lock()
+    if s.closed {
+        s.mu.Unlock()
+        return net.ErrClosed
+    }
+    s.listener = l
+    s.mu.Unlock()
+
+    for {
+        conn, err := l.Accept()
+        if err != nil {
+            s.mu.Lock()
+            closed := s.closed
+            s.mu.Unlock()
+            if closed {
+                return nil
+            }
+            return err
+        }
+
+        s.mu.Lock()
+        s.conns[conn] = struct{}{}
+        s.mu.Unlock()
+
+        go s.serveConn(conn)
+    }
+}
+
+// ListenAndServe listens on the TCP address and serves connections on it.
+func (s *Server) ListenAndServe(addr string) error {
+    l, err := net.Listen("tcp", addr)
+    if err != nil {
+        return err
+    }
+    return s.Serve(l)
+}
+
+// Close stops accepting connections and closes the open ones.
+// It does not close the bitcask datastore.
+func (s *Server) Close() error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.closed = true
+    for conn := range s.conns {
+        conn.Close()
+    }
+    if s.listener != nil {
+        return s.listener.Close()
+    }
+    return nil
+}
+
+// serveConn answers the commands of one client until it quits or the connection fails.
+func (s *Server) serveConn(conn net.Conn) {
+    defer func() {
+        s.mu.Lock()
+        delete(s.conns, conn)
+        s.mu.Unlock()
+        conn.Close()
+    }()
+
+    reader := bufio.NewReader(conn)
+    writer := bufio.NewWriter(conn)
+
+    for {
+        args, err := readCommand(reader)
+        if err == io.EOF {
+            return
+        }
+        if err != nil {
+            writeError(writer, "ERR " + err.Error())
+            writer.Flush()
+            return
+        }
+        if len(args) == 0 {
+            continue
+        }
+
+        quit := s.execute(writer, args)
+        if err := writer.Flush(); err != nil || quit {
+            return
+        }
+    }
+}
+
+// execute runs a command and writes its reply, it reports whether the client asked to quit.
+func (s *Server) execute(w *bufio.Writer, args []string) bool {
+    name := strings.ToUpper(args[0])
+    args = args[1:]
+
+    switch name {
+    case "PING":
+        switch len(args) {
+        case 0:
+            writeSimple(w, "PONG")
+        case 1:
+            writeBulk(w, args[0])
+        default:
+            writeArgsError(w, name)
+        }
+    case "GET":
+        if len(args) != 1 {
+            writeArgsError(w, name)
+            return false
+        }
+        value, err := s.store.Get(args[0])
+        if isKeyDoesNotExist(err) {
+            writeNull(w)
+        } else if err != nil {
+            writeStoreError(w, err)
+        } else {
+            writeBulk(w, value)
+        }
+    case "SET":
+        if len(args) != 2 {
+            writeArgsError(w, name)
+            return false
+        }
+        if err := s.store.Put(args[0], args[1]); err != nil {
+            writeStoreError(w, err)
+            return false
+        }
+        writeSimple(w, "OK")
+    case "DEL":
+        if len(args) == 0 {
+            writeArgsError(w, name)
+            return false
+        }
+        deleted := 0
+        for _, key := range args {
+            err := s.store.Delete(key)
+            if err == nil {
+                deleted++
+            } else if !isKeyDoesNotExist(err) {
+                writeStoreError(w, err)
+                return false
+            }
+        }
+        writeInteger(w, deleted)
+    case "EXISTS":
+        if len(args) == 0 {
+            writeArgsError(w, name)
+            return false
+        }
+        found := 0
+        for _, key := range args {
+            if s.store.Has(key) {
+                found++
+            }
+        }
+        writeInteger(w, found)
+    case "KEYS":
+        if len(args) != 1 {
+            writeArgsError(w, name)
+            return false
+        }
+        writeArray(w, s.matchingKeys(args[0]))
+    case "SCAN":
+        s.scan(w, args)
+    case "DBSIZE":
+        if len(args) != 0 {
+            writeArgsError(w, name)
+            return false
+        }
+        writeInteger(w, len(s.store.ListKeys()))
+    case "QUIT":
+        writeSimple(w, "OK")
+        return true
+    default:
+        writeError(w, fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(name)))
+    }
+
+    return false
+}
+
+// scan answers SCAN cursor [MATCH pattern] [COUNT count].
+// A cursor stands for the last key returned, the scan goes on after it in sorted order,
+// so keys deleted during a scan do not make it skip others. Keys added behind the cursor are missed.
+func (s *Server) scan(w *bufio.Writer, args []string) {
+    if len(args) == 0 || len(args) % 2 != 1 {
+        writeArgsError(w, "SCAN")
+        return
+    }
+
+    cursor, err := strconv.Atoi(args[0])
+    if err != nil || cursor < 0 {
+        writeError(w, "ERR invalid cursor")
+        return
+    }
+
+    pattern := "*"
+    count := defaultScanCount
+    for i := 1; i < len(args); i += 2 {
+        switch strings.ToUpper(args[i]) {
+        case "MATCH":
+            pattern = args[i + 1]
+        case "COUNT":
+            count, err = strconv.Atoi(args[i + 1])
+            if err != nil || count < 1 {
+                writeError(w, "ERR value is not an integer or out of range")
+                return
+            }
+        default:
+            writeError(w, "ERR syntax error")
+            return
+        }
+    }
+
+    keys := s.store.ListKeys()
+    sort.Strings(keys)
+
+    start := 0
+    if cursor != 0 {
+        s.mu.Lock()
+        lastKey, isExist := s.cursors[cursor]
+        s.mu.Unlock()
+        if !isExist {
+            writeError(w, "ERR invalid cursor")
+            return
+        }
+        start = sort.Search(len(keys), func(i int) bool {
+            return keys[i] > lastKey
+        })
+    }
+
+    end := start + count
+    if end > len(keys) {
+        end = len(keys)
+    }
+    var page []string
+    for _, key := range keys[start:end] {
+        if matched, _ := path.Match(pattern, key); matched {
+            page = append(page, key)
+        }
+    }
+    next := 0
+    if end < len(keys) {
+        next = s.saveCursor(keys[end - 1])
+    }
+
+    fmt.Fprintf(w, "*2\r\n")
+    writeBulk(w, strconv.Itoa(next))
+    writeArray(w, page)
+}
+
+// saveCursor keeps the last key returned by a SCAN call under a new cursor.
+// Cursors are numbers like those of redis, so clients parsing them as integers work.
+func (s *Server) saveCursor(lastKey string) int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.lastCursor++
+    s.cursors[s.lastCursor] = lastKey
+    delete(s.cursors, s.lastCursor - maxScanCursors)
+    return s.lastCursor
+}
+
+// matchingKeys lists the sorted keys matching a glob pattern.
+func (s *Server) matchingKeys(pattern string) []string {
+    var keys []string
+    for _, key := range s.store.ListKeys() {
+        if matched, _ := path.Match(pattern, key); matched {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// readCommand reads a command sent as a RESP array of bulk strings or as an inline command.
+func readCommand(r *bufio.Reader) ([]string, error) {
+    line, err := readLine(r)
+    if err != nil {
+        return nil, err
+    }
+
+    if !strings.HasPrefix(line, "*") {
+        return strings.Fields(line), nil
+    }
+
+    count, err := strconv.Atoi(line[1:])
+    if err != nil || count < 0 || count > 1024 * 1024 {
+        return nil, errProtocol
+    }
+
+    // The count is sent by the client, so arguments are appended as they arrive instead of allocated up front.
+    var args []string
+    total := 0
+    for i := 0; i < count; i++ {
+        line, err := readLine(r)
+        if err != nil {
+            return nil, err
+        }
+        if !strings.HasPrefix(line, "$") {
+            return nil, errProtocol
+        }
+
+        size, err := strconv.Atoi(line[1:])
+        if err != nil || size < 0 || size > maxBulkSize || total + size > maxRequestSize {
+            return nil, errProtocol
+        }
+        total += size
+
+        buf := make([]byte, size + 2)
+        if _, err := io.ReadFull(r, buf); err != nil {
+            return nil, err
+        }
+        if string(buf[size:]) != "\r\n" {
+            return nil, errProtocol
+        }
+        args = append(args, string(buf[:size]))
+    }
+
+    return args, nil
+}
+
+// readLine reads a line ended by CRLF without the line ending.
+// returns a protocol error once the line is longer than maxLineSize, before it is read to its end.
+func readLine(r *bufio.Reader) (string, error) {
+    var line []byte
+    for {
+        chunk, err := r.ReadSlice('\n')
+        line = append(line, chunk...)
+        if len(line) > maxLineSize {
+            return "", errProtocol
+        }
+        if err == bufio.ErrBufferFull {
+            continue
+        }
+        if err != nil {
+            if err == io.EOF && len(line) > 0 {
+                return "", io.ErrUnexpectedEOF
+            }
+            return "", err
+        }
+        return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+    }
+}
+
+// isKeyDoesNotExist reports whether err is the bitcask missing key error.
+func isKeyDoesNotExist(err error) bool {
+    _, ok := err.(bitcask.BitcaskError)
+    return ok && strings.HasSuffix(err.Error(), bitcask.KeyDoesNotExist)
+}
+
+// writeStoreError writes a bitcask error, write denied is reported like a redis read only replica.
+func writeStoreError(w *bufio.Writer, err error) {
+    if err.Error() == bitcask.WriteDenied {
+        writeError(w, "READONLY " + err.Error())
+        return
+    }
+    writeError(w, "ERR " + err.Error())
+}
+
+// writeArgsError writes the redis wrong number of arguments error.
+func writeArgsError(w *bufio.Writer, name string) {
+    writeError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+}
+
+// writeSimple writes a simple string reply.
+func writeSimple(w *bufio.Writer, s string) {
+    fmt.Fprintf(w, "+%s\r\n", s)
+}
+
+// writeError writes an error reply.
+// Line breaks are replaced, error messages hold keys and command names sent by the client.
+func writeError(w *bufio.Writer, s string) {
+    fmt.Fprintf(w, "-%s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(s))
+}
+
+// writeInteger writes an integer reply.
+func writeInteger(w *bufio.Writer, n int) {
+    fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+// writeBulk writes a bulk string reply.
+func writeBulk(w *bufio.Writer, s string) {
+    fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+// writeNull writes the null bulk string reply.
+func writeNull(w *bufio.Writer) {
+    fmt.Fprint(w, "$-1\r\n")
+}
+
+// writeArray writes an array of bulk strings reply.
+func writeArray(w *bufio.Writer, items []string) {
+    fmt.Fprintf(w, "*%d\r\n", len(items))
+    for _, item := range items {
+        writeBulk(w, item)
+    }
+}
@@ -0,0 +1,234 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"bitcask"
+)
+
+var testBitcaskPath = path.Join("testing_dir")
+
+// client is a minimal RESP client used to talk to the server in tests.
+type client struct {
+    conn net.Conn
+    reader *bufio.Reader
+}
+
+func TestServer(t *testing.T) {
+    store, _ := bitcask.Open(testBitcaskPath, bitcask.ReadWrite)
+    c, stop := startServer(t, store)
+    defer func() {
+        stop()
+        store.Close()
+        os.RemoveAll(testBitcaskPath)
+    }()
+
+    t.Run("ping", func(t *testing.T) {
+        assertReply(t, c.do(t, "PING"), "PONG")
+        assertReply(t, c.do(t, "PING", "hello"), "hello")
+    })
+
+    t.Run("set and get", func(t *testing.T) {
+        assertReply(t, c.do(t, "SET", "key1", "value\r\n1"), "OK")
+        assertReply(t, c.do(t, "GET", "key1"), "value\r\n1")
+        assertReply(t, c.do(t, "GET", "unknown"), nil)
+    })
+
+    t.Run("exists and del", func(t *testing.T) {
+        c.do(t, "SET", "key2", "value2")
+
+        assertReply(t, c.do(t, "EXISTS", "key1", "key2", "unknown"), 2)
+        assertReply(t, c.do(t, "DEL", "key2", "unknown"), 1)
+        assertReply(t, c.do(t, "EXISTS", "key2"), 0)
+    })
+
+    t.Run("keys and dbsize", func(t *testing.T) {
+        c.do(t, "SET", "user:1", "a")
+        c.do(t, "SET", "user:2", "b")
+
+        assertReply(t, c.do(t, "KEYS", "user:*"), []any{"user:1", "user:2"})
+        assertReply(t, c.do(t, "DBSIZE"), 3)
+    })
+
+    t.Run("scan all keys", func(t *testing.T) {
+        var got []string
+        cursor := "0"
+        for {
+            reply := c.do(t, "SCAN", cursor, "COUNT", "2").([]any)
+            cursor = reply[0].(string)
+            for _, key := range reply[1].([]any) {
+                got = append(got, key.(string))
+            }
+            if cursor == "0" {
+                break
+            }
+        }
+
+        want := []string{"key1", "user:1", "user:2"}
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("got:\n%v\nwant:\n%v", got, want)
+        }
+    })
+
+    t.Run("inline command", func(t *testing.T) {
+        fmt.Fprint(c.conn, "PING\r\n")
+        assertReply(t, c.read(t), "PONG")
+    })
+
+    t.Run("unknown command", func(t *testing.T) {
+        assertReply(t, c.do(t, "FLUSHALL"), fmt.Errorf("ERR unknown command 'flushall'"))
+    })
+
+    t.Run("negative lengths are protocol errors", func(t *testing.T) {
+        for _, request := range []string{"*-1\r\n", "*1\r\n$-1\r\n"} {
+            c, stop := startServer(t, store)
+            fmt.Fprint(c.conn, request)
+            assertReply(t, c.read(t), fmt.Errorf("ERR protocol error"))
+            stop()
+        }
+    })
+}
+
+func TestServerLimits(t *testing.T) {
+    store, _ := bitcask.OpenFS(bitcask.NewMemFileSystem(), testBitcaskPath, nil, bitcask.ReadWrite)
+    defer store.Close()
+
+    t.Run("long lines are protocol errors", func(t *testing.T) {
+        c, stop := startServer(t, store)
+        defer stop()
+
+        fmt.Fprint(c.conn, strings.Repeat("a", maxLineSize + 4096))
+        assertReply(t, c.read(t), fmt.Errorf("ERR protocol error"))
+    })
+
+    t.Run("store errors cannot inject replies", func(t *testing.T) {
+        c, stop := startServer(t, store)
+        defer stop()
+
+        store.SetMaxValueSize(4)
+        defer store.SetMaxValueSize(0)
+        assertReply(t, c.do(t, "SET", "key\r\n+OK", "value"), fmt.Errorf("ERR key  +OK: " + bitcask.ValueTooLarge))
+        assertReply(t, c.do(t, "PING"), "PONG")
+    })
+
+    t.Run("scan does not skip keys when keys are deleted", func(t *testing.T) {
+        c, stop := startServer(t, store)
+        defer stop()
+
+        for _, key := range []string{"a", "b", "c", "d"} {
+            store.Put(key, "value")
+        }
+        reply := c.do(t, "SCAN", "0", "COUNT", "2").([]any)
+        assertReply(t, reply[1], []any{"a", "b"})
+
+        store.Delete("a")
+        reply = c.do(t, "SCAN", reply[0].(string), "COUNT", "2").([]any)
+        assertReply(t, reply, []any{"0", []any{"c", "d"}})
+        assertReply(t, c.do(t, "SCAN", "12345"), fmt.Errorf("ERR invalid cursor"))
+    })
+}
+
+func TestServerReadOnly(t *testing.T) {
+    store, _ := bitcask.Open(testBitcaskPath, bitcask.ReadWrite)
+    store.Put("key1", "value1")
+    store.Close()
+
+    store, _ = bitcask.Open(testBitcaskPath)
+    c, stop := startServer(t, store)
+    defer func() {
+        stop()
+        store.Close()
+        os.RemoveAll(testBitcaskPath)
+    }()
+
+    assertReply(t, c.do(t, "SET", "key1", "value2"), fmt.Errorf("READONLY write permission denied"))
+    assertReply(t, c.do(t, "GET", "key1"), "value1")
+}
+
+// startServer serves the store on a loopback listener and connects a client to it.
+func startServer(t testing.TB, store *bitcask.Bitcask) (*client, func()) {
+    t.Helper()
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    s := New(store)
+    go s.Serve(l)
+
+    conn, err := net.Dial("tcp", l.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return &client{conn: conn, reader: bufio.NewReader(conn)}, func() {
+        conn.Close()
+        s.Close()
+    }
+}
+
+// do sends a command as a RESP array and reads its reply.
+func (c *client) do(t testing.TB, args ...string) any {
+    t.Helper()
+    fmt.Fprintf(c.conn, "*%d\r\n", len(args))
+    for _, arg := range args {
+        fmt.Fprintf(c.conn, "$%d\r\n%s\r\n", len(arg), arg)
+    }
+    return c.read(t)
+}
+
+// read reads one reply, errors are returned as error values and null as nil.
+func (c *client) read(t testing.TB) any {
+    t.Helper()
+    line, err := c.reader.ReadString('\n')
+    if err != nil {
+        t.Fatal(err)
+    }
+    line = strings.TrimSuffix(line, "\r\n")
+
+    switch line[0] {
+    case '+':
+        return line[1:]
+    case '-':
+        return fmt.Errorf("%s", line[1:])
+    case ':':
+        n, _ := strconv.Atoi(line[1:])
+        return n
+    case '$':
+        size, _ := strconv.Atoi(line[1:])
+        if size < 0 {
+            return nil
+        }
+        buf := make([]byte, size + 2)
+        if _, err := io.ReadFull(c.reader, buf); err != nil {
+            t.Fatal(err)
+        }
+        return string(buf[:size])
+    case '*':
+        count, _ := strconv.Atoi(line[1:])
+        items := []any{}
+        for i := 0; i < count; i++ {
+            items = append(items, c.read(t))
+        }
+        return items
+    }
+
+    t.Fatalf("unexpected reply %q", line)
+    return nil
+}
+
+func assertReply(t testing.TB, got any, want any) {
+    t.Helper()
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("got:\n%#v\nwant:\n%#v", got, want)
+    }
+}
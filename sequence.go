@@ -0,0 +1,120 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Name of the file keeping the next data file id and the last record sequence number of the datastore.
+const sequenceFile = ".sequence"
+
+// nameCounter makes the lock and keydir file names created by this process unique.
+var nameCounter uint64
+
+// uniqueName returns a file name starting with prefix that no other call returns,
+// in this process or in another one running at the same time.
+func uniqueName(prefix string) string {
+    return fmt.Sprintf("%s%d-%d-%d", prefix, time.Now().UnixMicro(), os.Getpid(), atomic.AddUint64(&nameCounter, 1))
+}
+
+// readSequence returns the next data file id and the last record sequence number of the datastore in dirPath on fsys.
+// The next file id is past every data file in the directory, even when the sequence file is missing or stale.
+func readSequence(fsys FileSystem, dirPath string) (int, int, error) {
+    var nextFileId, seq int
+
+    data, err := readFile(fsys, path.Join(dirPath, sequenceFile))
+    if err != nil && !os.IsNotExist(err) {
+        return 0, 0, err
+    }
+    // A damaged sequence file is ignored, the data files give the same answer.
+    if fields := strings.Fields(string(data)); len(fields) == 2 {
+        fileIdField, fileIdErr := strconv.Atoi(fields[0])
+        seqField, seqErr := strconv.Atoi(fields[1])
+        if fileIdErr == nil && seqErr == nil {
+            nextFileId, seq = fileIdField, seqField
+        }
+    }
+
+    files, err := fsys.ReadDir(dirPath)
+    if err != nil {
+        return 0, 0, err
+    }
+    for _, file := range files {
+        if !isDataFile(file.Name()) {
+            continue
+        }
+        if fileId, err := strconv.Atoi(file.Name()); err == nil && fileId >= nextFileId {
+            nextFileId = fileId + 1
+        }
+    }
+    if nextFileId < 1 {
+        nextFileId = 1
+    }
+
+    return nextFileId, seq, nil
+}
+
+// writeSequence persists the next data file id and the last record sequence number of the datastore in dirPath on fsys.
+func writeSequence(fsys FileSystem, dirPath string, nextFileId int, seq int) error {
+    sequencePath := path.Join(dirPath, sequenceFile)
+    if err := writeSyncedFile(fsys, sequencePath + ".tmp", fmt.Sprintf("%d %d\n", nextFileId, seq)); err != nil {
+        fsys.Remove(sequencePath + ".tmp")
+        return err
+    }
+
+    return renameSynced(fsys, sequencePath + ".tmp", sequencePath)
+}
+
+// loadSequence sets the next file id and the last sequence number of a writer,
+// the sequence number is raised past the records already replayed into the keydir.
+func (b *Bitcask) loadSequence() error {
+    nextFileId, seq, err := readSequence(b.fs, b.datastorePath)
+    if err != nil {
+        return err
+    }
+
+    b.nextFileId = nextFileId
+    if seq > b.seq {
+        b.seq = seq
+    }
+    return nil
+}
+
+// nextFileName allocates the name of a new data file, the caller holds the lock.
+// The allocation is persisted before the file is created so an id is never handed out twice.
+func (b *Bitcask) nextFileName() (string, error) {
+    fileId := b.nextFileId
+    b.nextFileId++
+    if err := writeSequence(b.fs, b.datastorePath, b.nextFileId, b.seq); err != nil {
+        return "", err
+    }
+
+    return strconv.Itoa(fileId), nil
+}
+
+// lastSequence returns the highest sequence number of the records in data file content.
+func lastSequence(fileData []byte) int {
+    var currentPos, seq int
+
+    for currentPos < len(fileData) {
+        line, n, err := splitFileLine(fileData[currentPos:])
+        if err != nil {
+            break
+        }
+        dataRec, err := extractFileLine(line)
+        if err != nil {
+            break
+        }
+        if dataRec.seq > seq {
+            seq = dataRec.seq
+        }
+        currentPos += n
+    }
+
+    return seq
+}
@@ -0,0 +1,102 @@
+package bitcask
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSequence(t *testing.T) {
+    t.Run("file ids increase and are persisted", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        assertString(t, b.activeFile.fileName, "1")
+        b.Put("key1", "value1")
+        fillActiveFile(b)
+        b.Merge()
+        lastFile := b.activeFile.fileName
+        b.Close()
+
+        dataFiles, _ := listDataFiles(testBitcaskPath)
+        for name := range dataFiles {
+            if compareFileIds(name, lastFile) > 0 {
+                t.Errorf("file %s is newer than the last active file %s", name, lastFile)
+            }
+            os.Remove(path.Join(testBitcaskPath, name))
+            os.Remove(path.Join(testBitcaskPath, hintFilePrefix + name))
+        }
+
+        // The ids of removed files are not handed out again.
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        if compareFileIds(b.activeFile.fileName, lastFile) <= 0 {
+            t.Errorf("got file id %s, want one after %s", b.activeFile.fileName, lastFile)
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("sequence numbers decide the last write, not timestamps", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.mu.Lock()
+        b.put("key1", "value1", 200)
+        b.mu.Unlock()
+        fillActiveFile(b)
+
+        // A clock that went backwards gives the newer write an older timestamp.
+        b.mu.Lock()
+        b.put("key1", "value2", 100)
+        b.mu.Unlock()
+        b.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        got, _ := b.Get("key1")
+        assertString(t, got, "value2")
+        if b.keyDir["key1"].tstamp != 100 {
+            t.Errorf("got tstamp %d, want 100", b.keyDir["key1"].tstamp)
+        }
+        b.Put("key1", "value3")
+        b.Merge()
+        b.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        got, _ = b.Get("key1")
+        assertString(t, got, "value3")
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("bulk load continues the sequence", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        seq := b.seq
+        b.Close()
+
+        if err := BulkLoad(testBitcaskPath, &countIterator{n: 1}); err != nil {
+            t.Fatal(err)
+        }
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        if b.keyDir["key1"].seq <= seq {
+            t.Errorf("got sequence number %d, want one after %d", b.keyDir["key1"].seq, seq)
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("lock names do not collide", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Close()
+
+        var readers []*Bitcask
+        for i := 0; i < 100; i++ {
+            r, _ := Open(testBitcaskPath, ReadOnly)
+            readers = append(readers, r)
+        }
+        if locks, _ := listFilesWithPrefix(testBitcaskPath, readLock); len(locks) != 100 {
+            t.Errorf("got %d lock files, want 100", len(locks))
+        }
+        for _, r := range readers {
+            r.Close()
+        }
+        os.RemoveAll(testBitcaskPath)
+    })
+}
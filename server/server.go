@@ -0,0 +1,199 @@
+// Package server exposes a bitcask datastore as a REST key/value service.
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"bitcask"
+)
+
+// Maximum size of a value accepted in a PUT request 64MB.
+const maxValueSize = 64 * 1024 * 1024
+
+// Server is an http.Handler serving the keys of a bitcask datastore.
+//
+//	GET    /keys/{key}      reads a value
+//	PUT    /keys/{key}      stores the request body as a value
+//	DELETE /keys/{key}      removes a key
+//	GET    /keys?prefix=    lists keys as a JSON array
+//	POST   /merge           merges the datastore
+//	GET    /stats           returns the datastore stats as JSON
+type Server struct {
+    store *bitcask.Bitcask
+}
+
+// New creates a server for the given bitcask datastore.
+func New(store *bitcask.Bitcask) *Server {
+    return &Server{store: store}
+}
+
+// ServeHTTP routes the request to the handler of its path.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    escapedPath := r.URL.EscapedPath()
+
+    switch {
+    case escapedPath == "/keys" || escapedPath == "/keys/":
+        s.handleList(w, r)
+    case strings.HasPrefix(escapedPath, "/keys/"):
+        // Keys are unescaped here so they may contain an encoded slash.
+        key, err := url.PathUnescape(strings.TrimPrefix(escapedPath, "/keys/"))
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        s.handleKey(w, r, key)
+    case escapedPath == "/merge":
+        s.handleMerge(w, r)
+    case escapedPath == "/stats":
+        s.handleStats(w, r)
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+// handleKey serves reads, writes and deletes of a single key.
+func (s *Server) handleKey(w http.ResponseWriter, r *http.Request, key string) {
+    switch r.Method {
+    case http.MethodGet, http.MethodHead:
+        value, err := s.store.GetReader(key)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        defer value.Close()
+        w.Header().Set("Content-Type", "application/octet-stream")
+        if sized, ok := value.(interface{ Size() int64 }); ok {
+            w.Header().Set("Content-Length", strconv.FormatInt(sized.Size(), 10))
+        }
+        if r.Method == http.MethodGet {
+            io.Copy(w, value)
+        }
+    case http.MethodPut:
+        // Bodies of known length are streamed into the datastore.
+        if r.ContentLength >= 0 {
+            if r.ContentLength > maxValueSize {
+                http.Error(w, bitcask.ValueTooLarge, http.StatusRequestEntityTooLarge)
+                return
+            }
+            if err := s.store.PutReader(key, r.Body, r.ContentLength); err != nil {
+                writeError(w, err)
+                return
+            }
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+
+        var value strings.Builder
+        if _, err := io.Copy(&value, http.MaxBytesReader(w, r.Body, maxValueSize)); err != nil {
+            status := http.StatusBadRequest
+            if value.Len() >= maxValueSize {
+                status = http.StatusRequestEntityTooLarge
+            }
+            http.Error(w, err.Error(), status)
+            return
+        }
+        if err := s.store.Put(key, value.String()); err != nil {
+            writeError(w, err)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    case http.MethodDelete:
+        if err := s.store.Delete(key); err != nil {
+            writeError(w, err)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        methodNotAllowed(w, "GET, HEAD, PUT, DELETE")
+    }
+}
+
+// handleList serves the sorted list of keys starting with the prefix query parameter.
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, "GET")
+        return
+    }
+
+    prefix := r.URL.Query().Get("prefix")
+    keys := []string{}
+    for _, key := range s.store.ListKeys() {
+        if strings.HasPrefix(key, prefix) {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+
+    writeJSON(w, keys)
+}
+
+// handleMerge merges the datastore, the merge stops when the client goes away.
+func (s *Server) handleMerge(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        methodNotAllowed(w, "POST")
+        return
+    }
+
+    if err := s.store.MergeContext(r.Context(), nil); err != nil {
+        writeError(w, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// handleStats serves the datastore stats.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, "GET")
+        return
+    }
+
+    stats, err := s.store.Stats()
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, stats)
+}
+
+// writeError writes the error with the status code matching it.
+func writeError(w http.ResponseWriter, err error) {
+    http.Error(w, err.Error(), statusCode(err))
+}
+
+// statusCode maps bitcask errors to http status codes.
+func statusCode(err error) int {
+    if _, ok := err.(bitcask.BitcaskError); !ok {
+        return http.StatusInternalServerError
+    }
+
+    message := err.Error()
+    switch {
+    case strings.HasSuffix(message, bitcask.KeyDoesNotExist):
+        return http.StatusNotFound
+    case message == bitcask.WriteDenied:
+        return http.StatusForbidden
+    case strings.HasSuffix(message, bitcask.ValueTooLarge):
+        return http.StatusRequestEntityTooLarge
+    default:
+        return http.StatusInternalServerError
+    }
+}
+
+// writeJSON writes v as a JSON response body.
+func writeJSON(w http.ResponseWriter, v any) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(v)
+}
+
+// methodNotAllowed rejects a request with a method the path does not support.
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+    w.Header().Set("Allow", allow)
+    http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
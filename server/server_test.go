@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"bitcask"
+)
+
+var testBitcaskPath = path.Join("testing_dir")
+
+func TestServer(t *testing.T) {
+    store, _ := bitcask.Open(testBitcaskPath, bitcask.ReadWrite)
+    ts := httptest.NewServer(New(store))
+    defer func() {
+        ts.Close()
+        store.Close()
+        os.RemoveAll(testBitcaskPath)
+    }()
+
+    t.Run("put and get a key", func(t *testing.T) {
+        res := request(t, http.MethodPut, ts.URL + "/keys/key1", "value1")
+        assertStatus(t, res, http.StatusNoContent)
+
+        res = request(t, http.MethodGet, ts.URL + "/keys/key1", "")
+        assertStatus(t, res, http.StatusOK)
+        assertBody(t, res, "value1")
+    })
+
+    t.Run("key with encoded slash", func(t *testing.T) {
+        request(t, http.MethodPut, ts.URL + "/keys/a%2Fb", "value")
+
+        got, _ := store.Get("a/b")
+        if got != "value" {
+            t.Errorf("got:%q, want:%q", got, "value")
+        }
+    })
+
+    t.Run("get not existing key", func(t *testing.T) {
+        res := request(t, http.MethodGet, ts.URL + "/keys/unknown", "")
+        assertStatus(t, res, http.StatusNotFound)
+    })
+
+    t.Run("delete a key", func(t *testing.T) {
+        request(t, http.MethodPut, ts.URL + "/keys/key2", "value2")
+
+        res := request(t, http.MethodDelete, ts.URL + "/keys/key2", "")
+        assertStatus(t, res, http.StatusNoContent)
+
+        res = request(t, http.MethodDelete, ts.URL + "/keys/key2", "")
+        assertStatus(t, res, http.StatusNotFound)
+    })
+
+    t.Run("list keys by prefix", func(t *testing.T) {
+        request(t, http.MethodPut, ts.URL + "/keys/user1", "1")
+        request(t, http.MethodPut, ts.URL + "/keys/user2", "2")
+
+        res := request(t, http.MethodGet, ts.URL + "/keys?prefix=user", "")
+        assertStatus(t, res, http.StatusOK)
+
+        var got []string
+        json.NewDecoder(res.Body).Decode(&got)
+        want := []string{"user1", "user2"}
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("got:\n%v\nwant:\n%v", got, want)
+        }
+    })
+
+    t.Run("merge and stats", func(t *testing.T) {
+        res := request(t, http.MethodPost, ts.URL + "/merge", "")
+        assertStatus(t, res, http.StatusNoContent)
+
+        res = request(t, http.MethodGet, ts.URL + "/stats", "")
+        assertStatus(t, res, http.StatusOK)
+
+        var got bitcask.Stats
+        json.NewDecoder(res.Body).Decode(&got)
+        if got.Keys != 4 {
+            t.Errorf("got %d keys, want 4", got.Keys)
+        }
+    })
+
+    t.Run("value over the maximum size", func(t *testing.T) {
+        store.SetMaxValueSize(4)
+        defer store.SetMaxValueSize(0)
+
+        res := request(t, http.MethodPut, ts.URL + "/keys/large", "large value")
+        assertStatus(t, res, http.StatusRequestEntityTooLarge)
+    })
+
+    t.Run("method not allowed", func(t *testing.T) {
+        res := request(t, http.MethodGet, ts.URL + "/merge", "")
+        assertStatus(t, res, http.StatusMethodNotAllowed)
+    })
+}
+
+func TestServerReadOnly(t *testing.T) {
+    store, _ := bitcask.Open(testBitcaskPath, bitcask.ReadWrite)
+    store.Put("key1", "value1")
+    store.Close()
+
+    store, _ = bitcask.Open(testBitcaskPath)
+    ts := httptest.NewServer(New(store))
+    defer func() {
+        ts.Close()
+        store.Close()
+        os.RemoveAll(testBitcaskPath)
+    }()
+
+    res := request(t, http.MethodPut, ts.URL + "/keys/key1", "value2")
+    assertStatus(t, res, http.StatusForbidden)
+
+    res = request(t, http.MethodPost, ts.URL + "/merge", "")
+    assertStatus(t, res, http.StatusForbidden)
+
+    res = request(t, http.MethodGet, ts.URL + "/keys/key1", "")
+    assertBody(t, res, "value1")
+}
+
+func request(t testing.TB, method string, url string, body string) *http.Response {
+    t.Helper()
+    req, _ := http.NewRequest(method, url, strings.NewReader(body))
+    res, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { res.Body.Close() })
+    return res
+}
+
+func assertStatus(t testing.TB, res *http.Response, want int) {
+    t.Helper()
+    if res.StatusCode != want {
+        t.Errorf("got status %d, want %d", res.StatusCode, want)
+    }
+}
+
+func assertBody(t testing.TB, res *http.Response, want string) {
+    t.Helper()
+    got, _ := io.ReadAll(res.Body)
+    if string(got) != want {
+        t.Errorf("got:\n%q\nwant:\n%q", got, want)
+    }
+}
@@ -0,0 +1,174 @@
+package bitcask
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+    // Error message when a released snapshot is read.
+    SnapshotReleased = "snapshot is released"
+
+    // Prefix of the names merged data files are moved to while a snapshot still reads them.
+    snapshotFilePrefix = "snapshot"
+)
+
+// Snapshot is a read only view of a datastore pinned to the keydir at the time it was taken.
+// Later writes are not seen, and the data files it reads are not removed by merges until it is released.
+// It is safe to use from multiple goroutines.
+type Snapshot struct {
+    b *Bitcask
+    keyDir map[string]record
+    // Data files the snapshot points to, pinned until it is released.
+    files map[string]bool
+    released bool
+}
+
+// snapshotPins tracks the data files pinned by open snapshots, it is guarded by the bitcask lock.
+type snapshotPins struct {
+    open map[*Snapshot]struct{}
+    // Number of open snapshots pinning each data file.
+    pins map[string]int
+    // Names merged data files were moved to while they are pinned.
+    moved map[string]string
+}
+
+// Snapshot returns a read only view of the datastore as it is now.
+// Merges of this process keep the data files of the snapshot until Release is called,
+// Release should be called as soon as the snapshot is no longer needed. Close releases the open snapshots.
+func (b *Bitcask) Snapshot() *Snapshot {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    s := &Snapshot{
+        b:      b,
+        keyDir: make(map[string]record, len(b.keyDir)),
+        files:  make(map[string]bool),
+    }
+    for key, recValue := range b.keyDir {
+        s.keyDir[key] = recValue
+        s.files[recValue.fileId] = true
+    }
+
+    if b.snapshots.open == nil {
+        b.snapshots.open = make(map[*Snapshot]struct{})
+        b.snapshots.pins = make(map[string]int)
+        b.snapshots.moved = make(map[string]string)
+    }
+    b.snapshots.open[s] = struct{}{}
+    for fileId := range s.files {
+        b.snapshots.pins[fileId]++
+    }
+
+    return s
+}
+
+// Get retrieves the value key had when the snapshot was taken.
+// returns an error if key did not exist then or the snapshot is released.
+func (s *Snapshot) Get(key string) (string, error) {
+    s.b.mu.RLock()
+    defer s.b.mu.RUnlock()
+
+    if s.released {
+        return "", BitcaskError(SnapshotReleased)
+    }
+    rec, isExist := s.keyDir[key]
+    if !isExist {
+        return "", BitcaskError(fmt.Sprintf("%s: %s", key, KeyDoesNotExist))
+    }
+
+    return s.b.readValue(key, rec)
+}
+
+// ListKeys returns the keys of the snapshot, none once it is released.
+func (s *Snapshot) ListKeys() []string {
+    s.b.mu.RLock()
+    defer s.b.mu.RUnlock()
+
+    if s.released {
+        return nil
+    }
+
+    var list []string
+    for key := range s.keyDir {
+        list = append(list, key)
+    }
+    return list
+}
+
+// Fold folds over the key/value pairs of the snapshot like Bitcask.Fold.
+func (s *Snapshot) Fold(fun func(string, string, any) any, acc any) any {
+    for _, key := range s.ListKeys() {
+        value, err := s.Get(key)
+        if err != nil {
+            continue
+        }
+        acc = fun(key, value, acc)
+    }
+    return acc
+}
+
+// Release unpins the data files of the snapshot, merged files no other snapshot reads are removed.
+// Releasing a snapshot twice does nothing.
+func (s *Snapshot) Release() {
+    s.b.mu.Lock()
+    defer s.b.mu.Unlock()
+
+    s.b.releaseSnapshot(s)
+}
+
+// releaseSnapshot releases s, the caller holds the lock.
+func (b *Bitcask) releaseSnapshot(s *Snapshot) {
+    if s.released {
+        return
+    }
+    s.released = true
+    delete(b.snapshots.open, s)
+
+    for fileId := range s.files {
+        b.snapshots.pins[fileId]--
+        if b.snapshots.pins[fileId] > 0 {
+            continue
+        }
+        delete(b.snapshots.pins, fileId)
+        if moved, isExist := b.snapshots.moved[fileId]; isExist {
+            b.fs.Remove(path.Join(b.datastorePath, moved))
+            delete(b.snapshots.moved, fileId)
+        }
+    }
+}
+
+// removeMergedFile removes a merged data file and its hint file, the caller holds the lock.
+// A file a snapshot still reads is moved out of the data files instead, so it is never replayed again.
+func (b *Bitcask) removeMergedFile(fileId string) {
+    b.fs.Remove(path.Join(b.datastorePath, hintFilePrefix + fileId))
+    if b.snapshots.pins[fileId] == 0 {
+        b.fs.Remove(path.Join(b.datastorePath, fileId))
+        return
+    }
+
+    moved := snapshotFilePrefix + fileId
+    if err := b.fs.Rename(path.Join(b.datastorePath, fileId), path.Join(b.datastorePath, moved)); err != nil {
+        return
+    }
+    b.snapshots.moved[fileId] = moved
+}
+
+// fileName returns the name a data file is read from, which differs once it is merged while pinned.
+func (p *snapshotPins) fileName(fileId string) string {
+    if moved, isExist := p.moved[fileId]; isExist {
+        return moved
+    }
+    return fileId
+}
+
+// removeSnapshotFiles removes the files moved for the snapshots of a process that did not close the datastore.
+func (b *Bitcask) removeSnapshotFiles() {
+    files, _ := b.fs.ReadDir(b.datastorePath)
+    for _, file := range files {
+        if strings.HasPrefix(file.Name(), snapshotFilePrefix) {
+            b.fs.Remove(path.Join(b.datastorePath, file.Name()))
+        }
+    }
+}
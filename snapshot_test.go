@@ -0,0 +1,113 @@
+package bitcask
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+    t.Run("snapshot keeps its view", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        b.Put("key2", "value2")
+
+        s := b.Snapshot()
+        b.Put("key1", "new")
+        b.Delete("key2")
+        b.Put("key3", "value3")
+
+        got, _ := s.Get("key1")
+        assertString(t, got, "value1")
+        got, _ = s.Get("key2")
+        assertString(t, got, "value2")
+        _, err := s.Get("key3")
+        assertError(t, err, "key3: " + KeyDoesNotExist)
+        if len(s.ListKeys()) != 2 {
+            t.Errorf("got %d keys in the snapshot, want 2", len(s.ListKeys()))
+        }
+        count := s.Fold(func(key string, value string, acc any) any {
+            return acc.(int) + 1
+        }, 0)
+        if count.(int) != 2 {
+            t.Errorf("got %d pairs folded, want 2", count)
+        }
+
+        got, _ = b.Get("key1")
+        assertString(t, got, "new")
+        s.Release()
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("merge keeps the files of a snapshot until it is released", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        pinned := b.activeFile.fileName
+        b.Put("key1", "value1")
+        fillActiveFile(b)
+
+        s := b.Snapshot()
+        b.Put("key1", "new")
+        fillActiveFile(b)
+        if err := b.Merge(); err != nil {
+            t.Fatal(err)
+        }
+
+        if dataFiles, _ := listDataFiles(testBitcaskPath); dataFiles[pinned] {
+            t.Errorf("expected merged file %s to leave the data files", pinned)
+        }
+        got, err := s.Get("key1")
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, got, "value1")
+        got, _ = b.Get("key1")
+        assertString(t, got, "new")
+
+        s.Release()
+        if _, err := os.Stat(path.Join(testBitcaskPath, snapshotFilePrefix + pinned)); !os.IsNotExist(err) {
+            t.Errorf("expected the file kept for the snapshot to be removed on release, got %v", err)
+        }
+        b.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        got, _ = b.Get("key1")
+        assertString(t, got, "new")
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("released snapshots cannot be read", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+
+        s := b.Snapshot()
+        s.Release()
+        s.Release()
+        _, err := s.Get("key1")
+        assertError(t, err, SnapshotReleased)
+
+        s = b.Snapshot()
+        b.Close()
+        _, err = s.Get("key1")
+        assertError(t, err, SnapshotReleased)
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("files left by a crashed process are removed on open", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        b.Close()
+
+        leftover := path.Join(testBitcaskPath, snapshotFilePrefix + "1")
+        os.WriteFile(leftover, []byte("old records"), fileMode)
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+            t.Errorf("expected the leftover snapshot file to be removed, got %v", err)
+        }
+        got, _ := b.Get("key1")
+        assertString(t, got, "value1")
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
@@ -0,0 +1,42 @@
+package bitcask
+
+// Stats describes the current state of an open bitcask datastore.
+type Stats struct {
+    Keys int `json:"keys"`
+    DataFiles int `json:"data_files"`
+    DataBytes int64 `json:"data_bytes"`
+    LiveBytes int64 `json:"live_bytes"`
+    CacheHits int64 `json:"cache_hits"`
+    CacheMisses int64 `json:"cache_misses"`
+}
+
+// Stats returns the number of keys and the size of the data files of a bitcask datastore.
+// LiveBytes counts only the bytes of records the keydir still points to.
+// CacheHits and CacheMisses count the Get calls served from and missed by the value cache.
+func (b *Bitcask) Stats() (Stats, error) {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    stats := Stats{Keys: len(b.keyDir)}
+    stats.CacheHits, stats.CacheMisses = b.cache.counters()
+
+    files, err := b.fs.ReadDir(b.datastorePath)
+    if err != nil {
+        return stats, err
+    }
+
+    for _, file := range files {
+        if !isDataFile(file.Name()) {
+            continue
+        }
+        stats.DataFiles++
+        stats.DataBytes += file.Size()
+    }
+    stats.DataBytes += int64(len(b.activeFile.buf))
+
+    for _, recValue := range b.keyDir {
+        stats.LiveBytes += int64(staticFields * numberFieldSize + recValue.keySize + recValue.valueSize + 1)
+    }
+
+    return stats, nil
+}
@@ -0,0 +1,27 @@
+package bitcask
+
+import (
+	"os"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+    b, _ := Open(testBitcaskPath, ReadWrite)
+    b.Put("key1", "value1")
+    b.Put("key1", "value2")
+    b.Put("key2", "value2")
+
+    stats, err := b.Stats()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    lineSize := int64(staticFields * numberFieldSize + len("key1") + len("value1") + 1)
+    want := Stats{Keys: 2, DataFiles: 1, DataBytes: 3 * lineSize, LiveBytes: 2 * lineSize}
+    if stats != want {
+        t.Errorf("got:%+v, want:%+v", stats, want)
+    }
+
+    b.Close()
+    os.RemoveAll(testBitcaskPath)
+}
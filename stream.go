@@ -0,0 +1,307 @@
+package bitcask
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+    // Error message when a value is longer than the maximum value size.
+    ValueTooLarge = "value too large"
+    // Error message when a reader ends before the declared value size.
+    ShortValue = "value shorter than its size"
+
+    // Prefix of the temporary files PutReader copies values into before they are appended.
+    spoolFilePrefix = ".spool"
+)
+
+// valueReader streams a value out of a data file and checks the record checksum at its end.
+type valueReader struct {
+    key string
+    section *io.SectionReader
+    file File
+    crc hash.Hash32
+    want uint32
+}
+
+// SetMaxValueSize makes Put and PutReader refuse values longer than size bytes.
+// A size of 0 removes the limit, which is the default.
+func (b *Bitcask) SetMaxValueSize(size int) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.config.maxValueSize = size
+}
+
+// checkValueSize returns an error if a value of size bytes is over the maximum value size, the caller holds the lock.
+func (b *Bitcask) checkValueSize(key string, size int) error {
+    if b.config.maxValueSize > 0 && size > b.config.maxValueSize {
+        return BitcaskError(fmt.Sprintf("%s: %s", key, ValueTooLarge))
+    }
+    return nil
+}
+
+// PutReader stores the size bytes read from r as the value of key without holding the value in memory.
+// The value is copied into a temporary file of the datastore before the lock is taken, so a slow r does not block other calls.
+// When compression or encryption is on the value is read whole first, since it is transformed as a whole.
+// Nothing is stored if r fails or ends early.
+// returns an error if the value is longer than the maximum value size.
+func (b *Bitcask) PutReader(key string, r io.Reader, size int64) error {
+    if b.config.writePermission == ReadOnly {
+        return BitcaskError(WriteDenied)
+    }
+    if size < 0 {
+        return BitcaskError(fmt.Sprintf("%s: %s", key, ShortValue))
+    }
+
+    b.mu.RLock()
+    err := b.checkValueSize(key, int(size))
+    encoded := b.config.codec != nil || b.config.keyring != nil
+    b.mu.RUnlock()
+    if err != nil {
+        return err
+    }
+    tstamp := int(time.Now().UnixMicro())
+
+    if encoded {
+        // Copy instead of allocating the declared size so a wrong size cannot exhaust memory.
+        var value bytes.Buffer
+        if _, err := io.CopyN(&value, r, size); err != nil {
+            return BitcaskError(fmt.Sprintf("%s: %s: %s", key, ShortValue, err))
+        }
+
+        b.mu.Lock()
+        err = b.put(key, value.String(), tstamp)
+    } else {
+        var spool string
+        spool, err = b.spoolValue(key, r, size)
+        if err != nil {
+            return err
+        }
+        defer b.fs.Remove(path.Join(b.datastorePath, spool))
+
+        b.mu.Lock()
+        err = b.putSpooled(key, spool, int(size), tstamp)
+    }
+    seq := b.commit.written
+    b.mu.Unlock()
+
+    if err != nil {
+        return err
+    }
+    return b.commitWrite(seq)
+}
+
+// spoolValue copies the size bytes of a value read from r into a new temporary file of the datastore and returns its name.
+// The caller removes the file once the value is stored.
+func (b *Bitcask) spoolValue(key string, r io.Reader, size int64) (string, error) {
+    spool := uniqueName(spoolFilePrefix)
+    file, err := b.fs.OpenFile(path.Join(b.datastorePath, spool), os.O_CREATE | os.O_EXCL | os.O_WRONLY)
+    if err != nil {
+        return "", err
+    }
+
+    n, err := io.Copy(file, io.LimitReader(r, size))
+    if err == nil && n < size {
+        err = BitcaskError(fmt.Sprintf("%s: %s", key, ShortValue))
+    }
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        b.fs.Remove(path.Join(b.datastorePath, spool))
+        return "", err
+    }
+
+    return spool, nil
+}
+
+// putSpooled appends a record whose value is copied from a spooled temporary file, the caller holds the lock.
+func (b *Bitcask) putSpooled(key string, spool string, size int, tstamp int) error {
+    file, err := b.fs.Open(path.Join(b.datastorePath, spool))
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    return b.putStream(key, file, size, tstamp)
+}
+
+// removeSpoolFiles removes the temporary value files of PutReader calls a process did not finish.
+func (b *Bitcask) removeSpoolFiles() {
+    files, _ := b.fs.ReadDir(b.datastorePath)
+    for _, file := range files {
+        if strings.HasPrefix(file.Name(), spoolFilePrefix) {
+            b.fs.Remove(path.Join(b.datastorePath, file.Name()))
+        }
+    }
+}
+
+// putStream appends a record whose value is copied from r straight into the active file, the caller holds the lock.
+// The header is written first with an empty checksum that is filled in once the value is written.
+func (b *Bitcask) putStream(key string, r io.Reader, size int, tstamp int) error {
+    lineSize := staticFields * numberFieldSize + len(key) + size + 1
+
+    if lineSize + b.activeFile.currentSize > maxFileSize {
+        if err := b.createActiveFile(); err != nil {
+            return err
+        }
+    }
+    b.seq++
+    body := padWithZero(tstamp) + padWithZero(len(key)) + padWithZero(size) + padWithZero(0) + padWithZero(b.seq) + key
+    // The value is written straight to the file, after the records buffered before it.
+    if err := b.flush(); err != nil {
+        return err
+    }
+    file := b.activeFile.file
+    start := b.activeFile.currentPos
+
+    crc := crc32.NewIEEE()
+    crc.Write([]byte(body))
+
+    _, err := file.Write([]byte(padWithZero(0) + body))
+    if err == nil {
+        var n int64
+        n, err = io.Copy(io.MultiWriter(file, crc), io.LimitReader(r, int64(size)))
+        if err == nil && n < int64(size) {
+            err = BitcaskError(fmt.Sprintf("%s: %s", key, ShortValue))
+        }
+    }
+    if err == nil {
+        _, err = file.Write([]byte("\n"))
+    }
+    if err == nil {
+        _, err = file.WriteAt([]byte(padWithZero(int(crc.Sum32()))), int64(start))
+    }
+    if err != nil {
+        // The partly written record is cut off so the file still ends with a whole record.
+        file.Truncate(int64(start))
+        file.Seek(int64(start), io.SeekStart)
+        return err
+    }
+
+    b.keyDir[key] = record{
+        fileId:    b.activeFile.fileName,
+        valueSize: size,
+        valuePos:  start + staticFields * numberFieldSize + len(key),
+        keySize:   len(key),
+        seq:       b.seq,
+        tstamp:    tstamp,
+    }
+
+    b.activeFile.currentPos += lineSize
+    b.activeFile.currentSize += lineSize
+    b.commit.written++
+    b.cache.remove(key)
+
+    // Streamed values are only read back for watchers that want them.
+    value := ""
+    if b.watchesValues(key) {
+        value, _ = b.get(key)
+    }
+    b.notifyWatchers(key, value, tstamp, 0)
+
+    if b.config.syncOption == SyncOnPut {
+        return b.sync()
+    }
+
+    return nil
+}
+
+// GetReader returns a reader streaming the value of key out of its data file.
+// The record checksum is checked when the reader reaches the end of the value.
+// Compressed and encrypted values are decoded whole before they are returned.
+// The reader has a Size method giving the length of the value and must be closed.
+// returns an error if key does not exist in the bitcask datastore.
+func (b *Bitcask) GetReader(key string) (io.ReadCloser, error) {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    rec, isExist := b.keyDir[key]
+    if !isExist {
+        return nil, BitcaskError(fmt.Sprintf("%s: %s", key, KeyDoesNotExist))
+    }
+
+    // Values still in the write buffer are copied out of it.
+    if b.bufferedLine(rec) != nil {
+        return b.decodedReader(key)
+    }
+
+    file, err := b.fs.Open(path.Join(b.datastorePath, rec.fileId))
+    if err != nil {
+        return nil, err
+    }
+
+    linePos := rec.valuePos - staticFields * numberFieldSize - rec.keySize
+    header := make([]byte, staticFields * numberFieldSize + rec.keySize)
+    if _, err := file.ReadAt(header, int64(linePos)); err != nil {
+        file.Close()
+        return nil, BitcaskError(fmt.Sprintf("%s: %s", key, TruncatedRecord))
+    }
+
+    dataRec, want, err := extractFileLineHeader(string(header))
+    if err != nil || dataRec.keySize != rec.keySize || dataRec.valueSize != rec.valueSize {
+        file.Close()
+        return nil, BitcaskError(fmt.Sprintf("%s: %s", key, CorruptedRecord))
+    }
+
+    if dataRec.flags &^ mergedFlag != 0 {
+        file.Close()
+        return b.decodedReader(key)
+    }
+
+    crc := crc32.NewIEEE()
+    crc.Write(header[numberFieldSize:])
+
+    return &valueReader{
+        key:     key,
+        section: io.NewSectionReader(file, int64(rec.valuePos), int64(rec.valueSize)),
+        file:    file,
+        crc:     crc,
+        want:    want,
+    }, nil
+}
+
+// decodedReader returns a reader over the value of key read whole by get, the caller holds the lock.
+func (b *Bitcask) decodedReader(key string) (io.ReadCloser, error) {
+    value, err := b.get(key)
+    if err != nil {
+        return nil, err
+    }
+    return &valueReader{key: key, section: io.NewSectionReader(strings.NewReader(value), 0, int64(len(value)))}, nil
+}
+
+// Read reads the next bytes of the value.
+// returns an error instead of io.EOF if the value does not match the record checksum.
+func (v *valueReader) Read(p []byte) (int, error) {
+    n, err := v.section.Read(p)
+    if v.crc == nil {
+        return n, err
+    }
+
+    v.crc.Write(p[:n])
+    if err == io.EOF && v.crc.Sum32() != v.want {
+        return n, BitcaskError(fmt.Sprintf("%s: %s", v.key, CorruptedRecord))
+    }
+    return n, err
+}
+
+// Size returns the length of the value.
+func (v *valueReader) Size() int64 {
+    return v.section.Size()
+}
+
+// Close releases the data file of the value.
+func (v *valueReader) Close() error {
+    if v.file == nil {
+        return nil
+    }
+    return v.file.Close()
+}
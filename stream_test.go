@@ -0,0 +1,143 @@
+package bitcask
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+    t.Run("put and get a streamed value", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        value := strings.Repeat("streamed\n", 500)
+
+        if err := b.PutReader("key1", strings.NewReader(value), int64(len(value))); err != nil {
+            t.Fatal(err)
+        }
+        b.Put("key2", "value2")
+
+        r, err := b.GetReader("key1")
+        if err != nil {
+            t.Fatal(err)
+        }
+        got, err := io.ReadAll(r)
+        r.Close()
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, string(got), value)
+
+        if size := r.(interface{ Size() int64 }).Size(); size != int64(len(value)) {
+            t.Errorf("got size %d, want %d", size, len(value))
+        }
+        b.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        got2, _ := b.Get("key1")
+        assertString(t, got2, value)
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("short reader leaves no record", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+
+        err := b.PutReader("key2", strings.NewReader("short"), 100)
+        if err == nil {
+            t.Fatalf("expected error for short reader")
+        }
+        b.Put("key3", "value3")
+        b.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        if _, err := b.Get("key2"); err == nil {
+            t.Errorf("expected partly written key to not exist")
+        }
+        got, _ := b.Get("key3")
+        assertString(t, got, "value3")
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("slow reader does not block the datastore", func(t *testing.T) {
+        for _, opts := range [][]ConfigOpt{{ReadWrite}, {ReadWrite, CompressValues}} {
+            b, _ := Open(testBitcaskPath, opts...)
+            r, w := io.Pipe()
+            done := make(chan error)
+            go func() {
+                done <- b.PutReader("key1", r, 10)
+            }()
+            w.Write([]byte("value"))
+
+            // The value is not whole yet, so PutReader is still reading it.
+            if err := b.Put("key2", "value2"); err != nil {
+                t.Fatal(err)
+            }
+            got, _ := b.Get("key2")
+            assertString(t, got, "value2")
+
+            w.Write([]byte("value"))
+            if err := <-done; err != nil {
+                t.Fatal(err)
+            }
+            got, _ = b.Get("key1")
+            assertString(t, got, "valuevalue")
+            b.Close()
+
+            files, _ := os.ReadDir(testBitcaskPath)
+            for _, file := range files {
+                if strings.HasPrefix(file.Name(), spoolFilePrefix) {
+                    t.Errorf("expected temporary file %s to be removed", file.Name())
+                }
+            }
+            os.RemoveAll(testBitcaskPath)
+        }
+    })
+
+    t.Run("corrupted streamed value fails at the end", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        b.Sync()
+        rec := b.keyDir["key1"]
+
+        file, _ := os.OpenFile(path.Join(testBitcaskPath, rec.fileId), os.O_WRONLY, fileMode)
+        file.WriteAt([]byte("X"), int64(rec.valuePos))
+        file.Close()
+
+        r, _ := b.GetReader("key1")
+        _, err := io.ReadAll(r)
+        r.Close()
+        assertError(t, err, "key1: " + CorruptedRecord)
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("compressed values are streamed too", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite, CompressValues)
+        value := strings.Repeat("compressible ", 100)
+        b.PutReader("key1", strings.NewReader(value), int64(len(value)))
+
+        r, _ := b.GetReader("key1")
+        got, _ := io.ReadAll(r)
+        r.Close()
+        assertString(t, string(got), value)
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("maximum value size", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.SetMaxValueSize(5)
+
+        assertError(t, b.Put("key1", "too long"), "key1: " + ValueTooLarge)
+        assertError(t, b.PutReader("key1", strings.NewReader("too long"), 8), "key1: " + ValueTooLarge)
+        if err := b.Put("key1", "short"); err != nil {
+            t.Error(err)
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
@@ -0,0 +1,181 @@
+package bitcask
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+    // GroupCommit makes every put wait until its write is synced to disk like SyncOnPut,
+    // but concurrent puts share one sync instead of syncing one by one.
+    GroupCommit ConfigOpt = 7
+)
+
+// groupCommit tracks which writes are synced to disk.
+type groupCommit struct {
+    // Number of writes appended so far, changed under the bitcask lock.
+    written uint64
+
+    mu sync.Mutex
+    cond *sync.Cond
+    synced uint64
+    // Number of writes a failed sync may have lost and the error of that sync.
+    failed uint64
+    err error
+    syncing bool
+    stop chan struct{}
+    done chan struct{}
+}
+
+// SetSyncInterval syncs the active file to disk every interval in the background,
+// so at most the writes of the last interval are lost on a crash.
+// An interval of 0 stops the background sync, which is the default.
+// returns an error if ReadWrite permission is not set.
+func (b *Bitcask) SetSyncInterval(interval time.Duration) error {
+    if b.config.writePermission == ReadOnly {
+        return BitcaskError(WriteDenied)
+    }
+
+    b.stopSyncInterval()
+    if interval <= 0 {
+        return nil
+    }
+
+    stop, done := make(chan struct{}), make(chan struct{})
+    b.commit.mu.Lock()
+    b.commit.stop, b.commit.done = stop, done
+    b.commit.mu.Unlock()
+
+    go func() {
+        defer close(done)
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case <-stop:
+                return
+            case <-ticker.C:
+                // A failed sync is tried again at the next tick, Sync reports it to the caller.
+                if written, err := b.syncActiveFile(); err == nil {
+                    b.markSynced(written)
+                }
+            }
+        }
+    }()
+
+    return nil
+}
+
+// stopSyncInterval stops the background sync and waits for it to end.
+// It must not be called with the bitcask lock held since the background sync takes it.
+func (b *Bitcask) stopSyncInterval() {
+    b.commit.mu.Lock()
+    stop, done := b.commit.stop, b.commit.done
+    b.commit.stop, b.commit.done = nil, nil
+    b.commit.mu.Unlock()
+
+    if stop != nil {
+        close(stop)
+        <-done
+    }
+}
+
+// commitWrite waits until the write numbered seq is synced to disk when GroupCommit is set.
+// The first waiting put syncs the active file for every write appended so far
+// while the other puts wait for it, so one sync serves them all.
+// returns the error of a failed sync that covered the write, even if a later sync succeeds.
+func (b *Bitcask) commitWrite(seq uint64) error {
+    if b.config.syncOption != GroupCommit {
+        return nil
+    }
+
+    b.commit.mu.Lock()
+    defer b.commit.mu.Unlock()
+
+    if b.commit.cond == nil {
+        b.commit.cond = sync.NewCond(&b.commit.mu)
+    }
+
+    for seq > b.commit.failed && b.commit.synced < seq {
+        if b.commit.syncing {
+            b.commit.cond.Wait()
+            continue
+        }
+
+        b.commit.syncing = true
+        b.commit.mu.Unlock()
+        written, err := b.syncActiveFile()
+        b.commit.mu.Lock()
+        b.commit.syncing = false
+        if err == nil && written > b.commit.synced {
+            b.commit.synced = written
+        }
+        b.commit.cond.Broadcast()
+
+        if err != nil {
+            return err
+        }
+    }
+
+    if seq <= b.commit.failed {
+        return b.commit.err
+    }
+    return nil
+}
+
+// markSynced records that the first written writes are synced to disk.
+func (b *Bitcask) markSynced(written uint64) {
+    b.commit.mu.Lock()
+    defer b.commit.mu.Unlock()
+
+    if written > b.commit.synced {
+        b.commit.synced = written
+    }
+    if b.commit.cond != nil {
+        b.commit.cond.Broadcast()
+    }
+}
+
+// failCommits makes the group commits of the first written writes fail with the error of a sync.
+// Syncing again cannot tell whether the failed sync lost them, so they never succeed.
+func (b *Bitcask) failCommits(written uint64, err error) {
+    b.commit.mu.Lock()
+    defer b.commit.mu.Unlock()
+
+    if written > b.commit.failed {
+        b.commit.failed = written
+        b.commit.err = err
+    }
+    if b.commit.cond != nil {
+        b.commit.cond.Broadcast()
+    }
+}
+
+// syncActiveFile flushes the write buffer and syncs the active file without holding the lock during the sync,
+// so puts go on meanwhile.
+// returns the number of writes made durable by the sync.
+// A file closed meanwhile was synced by the rotation or Close that closed it,
+// which marked its writes synced or failed, so nothing is made durable here.
+func (b *Bitcask) syncActiveFile() (uint64, error) {
+    b.mu.Lock()
+    err := b.flush()
+    file, written := b.activeFile.file, b.commit.written
+    b.mu.Unlock()
+
+    if err != nil {
+        return 0, err
+    }
+
+    if err := file.Sync(); err != nil {
+        if errors.Is(err, os.ErrClosed) {
+            return 0, nil
+        }
+        b.failCommits(written, err)
+        return 0, err
+    }
+
+    return written, nil
+}
@@ -0,0 +1,144 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errSyncFailed = errors.New("sync failed")
+
+// failingSyncFS makes the data files it opens fail to sync while failing is set.
+type failingSyncFS struct {
+    FileSystem
+    mu sync.Mutex
+    failing bool
+}
+
+// failingSyncFile is a data file opened by failingSyncFS.
+type failingSyncFile struct {
+    File
+    fsys *failingSyncFS
+}
+
+func (f *failingSyncFS) OpenFile(name string, flag int) (File, error) {
+    file, err := f.FileSystem.OpenFile(name, flag)
+    if err != nil || !isDataFile(path.Base(name)) {
+        return file, err
+    }
+    return &failingSyncFile{File: file, fsys: f}, nil
+}
+
+func (f *failingSyncFS) setFailing(failing bool) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.failing = failing
+}
+
+func (f *failingSyncFile) Sync() error {
+    f.fsys.mu.Lock()
+    defer f.fsys.mu.Unlock()
+    if f.fsys.failing {
+        return errSyncFailed
+    }
+    return f.File.Sync()
+}
+
+func TestGroupCommit(t *testing.T) {
+    t.Run("concurrent puts return once synced", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite, GroupCommit)
+
+        var wg sync.WaitGroup
+        for i := 0; i < 50; i++ {
+            wg.Add(1)
+            go func(i int) {
+                defer wg.Done()
+                if err := b.Put(fmt.Sprintf("key%d", i), "value"); err != nil {
+                    t.Error(err)
+                }
+            }(i)
+        }
+        wg.Wait()
+
+        if b.commit.synced != 50 {
+            t.Errorf("got %d synced writes, want 50", b.commit.synced)
+        }
+        b.Delete("key1")
+        if b.commit.synced != 51 {
+            t.Errorf("got %d synced writes after delete, want 51", b.commit.synced)
+        }
+        b.Close()
+
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        if len(b.ListKeys()) != 49 {
+            t.Errorf("got %d keys, want 49", len(b.ListKeys()))
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("failed rotation sync fails the pending group commits", func(t *testing.T) {
+        fsys := &failingSyncFS{FileSystem: NewMemFileSystem()}
+        b, _ := OpenFS(fsys, testBitcaskPath, nil, ReadWrite, GroupCommit)
+
+        b.mu.Lock()
+        b.put("key1", "value1", 1)
+        seq := b.commit.written
+        activeFile := b.activeFile.fileName
+        fsys.setFailing(true)
+        err := b.createActiveFile()
+        b.mu.Unlock()
+        assertError(t, err, errSyncFailed.Error())
+        if b.activeFile.fileName != activeFile {
+            t.Errorf("expected the active file to stay %s, got %s", activeFile, b.activeFile.fileName)
+        }
+
+        fsys.setFailing(false)
+        if err := b.commitWrite(seq); err != errSyncFailed {
+            t.Errorf("expected the pending commit to fail with the rotation sync, got %v", err)
+        }
+        if err := b.Put("key2", "value2"); err != nil {
+            t.Errorf("expected later writes to commit, got %v", err)
+        }
+        b.Close()
+    })
+
+    t.Run("interval sync", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        if err := b.SetSyncInterval(time.Millisecond); err != nil {
+            t.Fatal(err)
+        }
+        b.Put("key1", "value1")
+
+        deadline := time.Now().Add(time.Second)
+        for {
+            b.commit.mu.Lock()
+            synced := b.commit.synced
+            b.commit.mu.Unlock()
+            if synced == 1 {
+                break
+            }
+            if time.Now().After(deadline) {
+                t.Fatalf("write was not synced by the interval sync")
+            }
+            time.Sleep(time.Millisecond)
+        }
+
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("interval sync needs write permission", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Close()
+
+        r, _ := Open(testBitcaskPath, ReadOnly)
+        assertError(t, r.SetSyncInterval(time.Second), WriteDenied)
+        r.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
@@ -0,0 +1,242 @@
+package bitcask
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VerifyReport describes the state of a bitcask datastore checked by Verify or Repair.
+type VerifyReport struct {
+    DataFiles int
+    HintFiles int
+    Records int
+    Problems []VerifyProblem
+    OrphanedLocks []string
+    OrphanedKeyDirFiles []string
+    // Lock and keydir files of processes still running, Repair leaves them in place.
+    InUse []string
+    Repaired []string
+}
+
+// VerifyProblem points to a damaged place in a datastore file.
+type VerifyProblem struct {
+    File string
+    Offset int
+    Reason string
+}
+
+// dataFileEntry is a valid record found while scanning a data file.
+type dataFileEntry struct {
+    key string
+    valueSize int
+    seq int
+}
+
+// Healthy reports whether no damaged records, leftover files or processes using the datastore were found.
+func (r VerifyReport) Healthy() bool {
+    return len(r.Problems) == 0 && len(r.OrphanedLocks) == 0 && len(r.OrphanedKeyDirFiles) == 0 && len(r.InUse) == 0
+}
+
+// Verify walks every data file and hint file of a bitcask datastore,
+// checks record framing and checksums and cross-checks hint entries against data files.
+// It must run while no process has the datastore open, lock and keydir files are reported as orphaned
+// once the process named in them is gone and as in use while it still runs.
+// returns an error if the datastore is not in the current format, Open with ReadWrite migrates older ones.
+func Verify(dirPath string) (VerifyReport, error) {
+    return verifyDatastore(OSFileSystem, dirPath, false)
+}
+
+// Repair verifies a bitcask datastore like Verify and fixes what it finds:
+// damaged data file tails are truncated, broken hint files are regenerated
+// and orphaned lock and keydir files are removed, those of running processes are kept.
+// A data file is truncated at its first damaged record, the valid records after it are dropped too.
+// A datastore not in the current format is left untouched.
+func Repair(dirPath string) (VerifyReport, error) {
+    return verifyDatastore(OSFileSystem, dirPath, true)
+}
+
+// verifyDatastore does the checks of Verify on the datastore in dirPath of fsys and applies the fixes of Repair when repair is set.
+func verifyDatastore(fsys FileSystem, dirPath string, repair bool) (VerifyReport, error) {
+    var report VerifyReport
+    var dataFiles, hintFiles []string
+
+    files, err := fsys.ReadDir(dirPath)
+    if err != nil {
+        return report, BitcaskError(fmt.Sprintf("%s: %s", dirPath, CannotOpenThisDir))
+    }
+    // Files of another format would all look damaged and be truncated away.
+    if err := checkFormat(fsys, dirPath, false); err != nil {
+        return report, err
+    }
+
+    for _, file := range files {
+        name := file.Name()
+        switch {
+        case (strings.HasPrefix(name, readLock) || strings.HasPrefix(name, writeLock) ||
+        strings.HasPrefix(name, keyDirFilePrefix)) && ownerRunning(name):
+            report.InUse = append(report.InUse, name)
+        case strings.HasPrefix(name, readLock) || strings.HasPrefix(name, writeLock):
+            report.OrphanedLocks = append(report.OrphanedLocks, name)
+        case strings.HasPrefix(name, keyDirFilePrefix):
+            report.OrphanedKeyDirFiles = append(report.OrphanedKeyDirFiles, name)
+        case isHintFile(name):
+            hintFiles = append(hintFiles, name)
+        case isDataFile(name):
+            dataFiles = append(dataFiles, name)
+        }
+    }
+    sort.Strings(dataFiles)
+    sort.Strings(hintFiles)
+
+    report.DataFiles = len(dataFiles)
+    report.HintFiles = len(hintFiles)
+
+    dataEntries := make(map[string]map[int]dataFileEntry)
+    truncated := make(map[string]bool)
+
+    for _, name := range dataFiles {
+        entries, validSize, problem, err := scanDataFile(fsys, path.Join(dirPath, name))
+        if err != nil {
+            return report, err
+        }
+        dataEntries[name] = entries
+        report.Records += len(entries)
+
+        if problem != "" {
+            report.Problems = append(report.Problems, VerifyProblem{File: name, Offset: validSize, Reason: problem})
+            if repair {
+                if err := truncateFile(fsys, path.Join(dirPath, name), int64(validSize)); err != nil {
+                    return report, err
+                }
+                truncated[name] = true
+                report.Repaired = append(report.Repaired, name)
+            }
+        }
+    }
+
+    for _, name := range hintFiles {
+        fileId := strings.TrimPrefix(name, hintFilePrefix)
+        entries, isExist := dataEntries[fileId]
+        if !isExist {
+            report.Problems = append(report.Problems, VerifyProblem{File: name, Reason: "hint file without data file"})
+            if repair {
+                if err := fsys.Remove(path.Join(dirPath, name)); err != nil {
+                    return report, err
+                }
+                report.Repaired = append(report.Repaired, name)
+            }
+            continue
+        }
+
+        problems, err := checkHintFile(fsys, path.Join(dirPath, name), fileId, entries)
+        if err != nil {
+            return report, err
+        }
+        report.Problems = append(report.Problems, problems...)
+
+        if repair && (len(problems) > 0 || truncated[fileId]) {
+            fileData, err := readFile(fsys, path.Join(dirPath, fileId))
+            if err != nil {
+                return report, err
+            }
+            if err := writeHintFile(fsys, dirPath, fileId, fileData); err != nil {
+                return report, err
+            }
+            report.Repaired = append(report.Repaired, name)
+        }
+    }
+
+    if repair {
+        for _, name := range append(report.OrphanedLocks, report.OrphanedKeyDirFiles...) {
+            if err := fsys.Remove(path.Join(dirPath, name)); err != nil {
+                return report, err
+            }
+            report.Repaired = append(report.Repaired, name)
+        }
+    }
+
+    return report, nil
+}
+
+// ownerRunning reports whether the process that created a lock or keydir file still runs.
+// Their names end with the pid of the process and a counter, see uniqueName.
+func ownerRunning(name string) bool {
+    fields := strings.Split(name, "-")
+    if len(fields) < 3 {
+        return false
+    }
+    pid, err := strconv.Atoi(fields[len(fields) - 2])
+    return err == nil && processAlive(pid)
+}
+
+// scanDataFile reads all valid records of a data file of fsys keyed by their value position.
+// returns the size of the valid part of the file and the reason the scan stopped early if any.
+func scanDataFile(fsys FileSystem, filePath string) (map[int]dataFileEntry, int, string, error) {
+    var currentPos int = 0
+    entries := make(map[int]dataFileEntry)
+
+    fileData, err := readFile(fsys, filePath)
+    if err != nil {
+        return nil, 0, "", err
+    }
+
+    for currentPos < len(fileData) {
+        line, n, err := splitFileLine(fileData[currentPos:])
+        if err != nil {
+            return entries, currentPos, err.Error(), nil
+        }
+        dataRec, err := extractFileLine(line)
+        if err != nil {
+            return entries, currentPos, err.Error(), nil
+        }
+        valueSize := dataRec.valueSize
+        if dataRec.flags & tombstoneFlag != 0 {
+            valueSize = hintTombstoneSize
+        }
+
+        entries[currentPos + staticFields * numberFieldSize + dataRec.keySize] = dataFileEntry{
+            key:       dataRec.key,
+            valueSize: valueSize,
+            seq:       dataRec.seq,
+        }
+        currentPos += n
+    }
+
+    return entries, currentPos, "", nil
+}
+
+// checkHintFile checks that every hint entry of a hint file of fsys points to a matching record in its data file.
+func checkHintFile(fsys FileSystem, filePath string, fileId string, entries map[int]dataFileEntry) ([]VerifyProblem, error) {
+    var problems []VerifyProblem
+    var currentPos int = 0
+    name := path.Base(filePath)
+
+    hintFileData, err := readFile(fsys, filePath)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, line := range strings.SplitAfter(string(hintFileData), "\n") {
+        if line == "" {
+            break
+        }
+
+        key, recValue, _, err := extractHintFileLine(strings.TrimSuffix(line, "\n"), fileId)
+        if err != nil {
+            problems = append(problems, VerifyProblem{File: name, Offset: currentPos, Reason: err.Error()})
+        } else if entry, isExist := entries[recValue.valuePos]; !isExist || entry.key != key ||
+        entry.valueSize != recValue.valueSize || entry.seq != recValue.seq {
+            problems = append(problems, VerifyProblem{
+                File:   name,
+                Offset: currentPos,
+                Reason: fmt.Sprintf("%s: hint entry does not match data file", key),
+            })
+        }
+        currentPos += len(line)
+    }
+
+    return problems, nil
+}
@@ -0,0 +1,151 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+    t.Run("healthy datastore", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        for i := 0; i < 100; i++ {
+            b.Put(fmt.Sprintf("key%d", i + 1), fmt.Sprintf("value%d", i + 1))
+        }
+        b.Merge()
+        b.Close()
+
+        report, err := Verify(testBitcaskPath)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !report.Healthy() {
+            t.Errorf("expected healthy datastore, got: %+v", report)
+        }
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("damaged data file tail", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        b.Put("key2", "value2")
+        activeFile := b.activeFile.fileName
+        b.Close()
+
+        file, _ := os.OpenFile(path.Join(testBitcaskPath, activeFile), os.O_APPEND | os.O_WRONLY, fileMode)
+        file.WriteString("0000000000garbage")
+        file.Close()
+
+        report, _ := Verify(testBitcaskPath)
+        if len(report.Problems) != 1 || report.Problems[0].File != activeFile {
+            t.Fatalf("expected one problem in %s, got: %+v", activeFile, report.Problems)
+        }
+
+        Repair(testBitcaskPath)
+        report, _ = Verify(testBitcaskPath)
+        if !report.Healthy() {
+            t.Errorf("expected healthy datastore after repair, got: %+v", report)
+        }
+
+        b, _ = Open(testBitcaskPath)
+        got, _ := b.Get("key2")
+        b.Close()
+
+        assertString(t, got, "value2")
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("corrupted value is detected by get", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        b.Sync()
+        activeFile := b.activeFile.fileName
+
+        fileData, _ := os.ReadFile(path.Join(testBitcaskPath, activeFile))
+        os.WriteFile(path.Join(testBitcaskPath, activeFile), []byte(strings.Replace(string(fileData), "value1", "value2", 1)), fileMode)
+
+        _, err := b.Get("key1")
+        b.Close()
+
+        assertError(t, err, "key1: corrupted record")
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("hint file not matching data file", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Put("key1", "value1")
+        b.Put("key2", "value2")
+        b.Close()
+        b, _ = Open(testBitcaskPath, ReadWrite)
+        b.Merge()
+        b.Close()
+
+        hintFiles, _ := listFilesWithPrefix(testBitcaskPath, hintFilePrefix)
+        os.WriteFile(hintFiles[0], []byte(buildHintFileLine(record{valuePos: 5, valueSize: 6}, "key1", 0) + "\n"), fileMode)
+
+        report, _ := Verify(testBitcaskPath)
+        if len(report.Problems) != 1 {
+            t.Fatalf("expected one problem, got: %+v", report.Problems)
+        }
+
+        Repair(testBitcaskPath)
+        report, _ = Verify(testBitcaskPath)
+        if !report.Healthy() {
+            t.Errorf("expected healthy datastore after repair, got: %+v", report)
+        }
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("orphaned lock file", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        b.Close()
+        // The lock of a writer that crashed, no process runs with its pid.
+        lock := fmt.Sprintf("%s1-%d-1", writeLock, 1 << 30)
+        os.WriteFile(path.Join(testBitcaskPath, lock), nil, fileMode)
+
+        report, _ := Verify(testBitcaskPath)
+        if len(report.OrphanedLocks) != 1 || report.OrphanedLocks[0] != lock {
+            t.Errorf("expected one orphaned lock, got: %v", report.OrphanedLocks)
+        }
+
+        Repair(testBitcaskPath)
+        b, err := Open(testBitcaskPath)
+        if err != nil {
+            t.Errorf("expected no error after repair, got: %v", err)
+        }
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("lock of a running process is kept", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+
+        report, _ := Repair(testBitcaskPath)
+        if len(report.InUse) != 1 || len(report.OrphanedLocks) != 0 || report.Healthy() {
+            t.Errorf("expected the lock to be in use, got: %+v", report)
+        }
+        if _, err := Open(testBitcaskPath, ReadWrite); err == nil {
+            t.Errorf("expected the writer to keep its lock")
+        }
+
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
+
+// listFilesWithPrefix lists the paths of the files in dir starting with prefix.
+func listFilesWithPrefix(dir string, prefix string) ([]string, error) {
+    var paths []string
+    files, err := os.ReadDir(dir)
+    if err != nil {
+        return nil, err
+    }
+    for _, file := range files {
+        if strings.HasPrefix(file.Name(), prefix) {
+            paths = append(paths, path.Join(dir, file.Name()))
+        }
+    }
+    return paths, nil
+}
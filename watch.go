@@ -0,0 +1,266 @@
+package bitcask
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+    // Error message when a tail position is in a data file that was merged away.
+    TailPositionMerged = "tail position was merged away"
+)
+
+const (
+    // PutEvent is emitted when a key is stored.
+    PutEvent EventType = 0
+    // DeleteEvent is emitted when a key is removed.
+    DeleteEvent EventType = 1
+
+    // WatchValues makes Watch include the stored value in put events.
+    WatchValues WatchOpt = 0
+)
+
+// Number of events buffered for a watcher before it is dropped.
+const watchBufferSize = 256
+
+// EventType tells what kind of change an event describes.
+type EventType int
+
+// WatchOpt is a type to the options passed to Watch.
+type WatchOpt int
+
+// Event describes a change applied to a bitcask datastore.
+// FileId and Offset point right after the record of the change in the data files,
+// so a Tail can resume from there.
+type Event struct {
+    Type EventType
+    Key string
+    Value string
+    Tstamp int
+    FileId string
+    Offset int
+}
+
+// watcher is a Watch subscription.
+type watcher struct {
+    prefix string
+    values bool
+    events chan Event
+}
+
+// Tail reads the changes recorded in the data files of a datastore from a position on.
+// It only reads files, so it works on a datastore opened by another process
+// and sees records still in the write buffer of the writer once they are flushed.
+type Tail struct {
+    fs FileSystem
+    dirPath string
+    prefix string
+    fileId string
+    offset int
+    keyring *Keyring
+    // End offsets of the older files the tail moved past, read again from there if they grow.
+    passed map[string]int
+}
+
+// Watch emits an event for every put and delete of a key starting with prefix.
+// If the watcher falls more than a buffer of events behind, its channel is closed
+// and it should resume with a Tail from the position of the last event it got.
+// cancel stops the watch and closes the channel.
+func (b *Bitcask) Watch(prefix string, opts ...WatchOpt) (<-chan Event, func()) {
+    w := &watcher{prefix: prefix, events: make(chan Event, watchBufferSize)}
+    for _, opt := range opts {
+        if opt == WatchValues {
+            w.values = true
+        }
+    }
+
+    b.mu.Lock()
+    if b.watchers == nil {
+        b.watchers = make(map[*watcher]struct{})
+    }
+    b.watchers[w] = struct{}{}
+    b.mu.Unlock()
+
+    cancel := func() {
+        b.mu.Lock()
+        defer b.mu.Unlock()
+        b.removeWatcher(w)
+    }
+
+    return w.events, cancel
+}
+
+// notifyWatchers sends the event of a record just written to the active file, the caller holds the lock.
+func (b *Bitcask) notifyWatchers(key string, value string, tstamp int, flags int) {
+    if len(b.watchers) == 0 {
+        return
+    }
+
+    event := Event{
+        Type:   PutEvent,
+        Key:    key,
+        Tstamp: tstamp,
+        FileId: b.activeFile.fileName,
+        Offset: b.activeFile.currentPos,
+    }
+    if flags & tombstoneFlag != 0 {
+        event.Type = DeleteEvent
+    }
+
+    for w := range b.watchers {
+        if !strings.HasPrefix(key, w.prefix) {
+            continue
+        }
+
+        wEvent := event
+        if w.values && event.Type == PutEvent {
+            wEvent.Value = value
+        }
+
+        select {
+        case w.events <- wEvent:
+        default:
+            b.removeWatcher(w)
+        }
+    }
+}
+
+// watchesValues reports whether a watcher wants the values of key, the caller holds the lock.
+func (b *Bitcask) watchesValues(key string) bool {
+    for w := range b.watchers {
+        if w.values && strings.HasPrefix(key, w.prefix) {
+            return true
+        }
+    }
+    return false
+}
+
+// removeWatcher stops a watcher and closes its channel, the caller holds the lock.
+func (b *Bitcask) removeWatcher(w *watcher) {
+    if _, isExist := b.watchers[w]; isExist {
+        delete(b.watchers, w)
+        close(w.events)
+    }
+}
+
+// OpenTail creates a tail over the data files in dirPath that starts after offset in fileId.
+// An empty fileId starts from the oldest data file.
+// Only keys starting with prefix are reported.
+func OpenTail(dirPath string, prefix string, fileId string, offset int) *Tail {
+    return &Tail{fs: OSFileSystem, dirPath: dirPath, prefix: prefix, fileId: fileId, offset: offset, passed: make(map[string]int)}
+}
+
+// SetFileSystem makes the tail read the data files from fsys instead of OSFileSystem.
+func (t *Tail) SetFileSystem(fsys FileSystem) {
+    t.fs = fsys
+}
+
+// SetKeyring gives the tail the keys to decrypt the records of an encrypted datastore.
+func (t *Tail) SetKeyring(keyring *Keyring) {
+    t.keyring = keyring
+}
+
+// Next returns the changes written since the previous call, with their values.
+// Records rewritten by merge repeat older writes and are not reported.
+// returns an error if the data file of the tail position was merged away,
+// since changes in it may have been missed.
+func (t *Tail) Next() ([]Event, error) {
+    var events []Event
+
+    files, err := t.fs.ReadDir(t.dirPath)
+    if err != nil {
+        return nil, BitcaskError(fmt.Sprintf("%s: %s", t.dirPath, CannotOpenThisDir))
+    }
+
+    var names []string
+    listed := make(map[string]bool)
+    for _, file := range files {
+        name := file.Name()
+        _, isPassed := t.passed[name]
+        if isDataFile(name) && (t.fileId == "" || compareFileIds(name, t.fileId) >= 0 || isPassed) {
+            names = append(names, name)
+            listed[name] = true
+        }
+    }
+    sort.Slice(names, func(i, j int) bool {
+        return compareFileIds(names[i], names[j]) < 0
+    })
+
+    if t.fileId != "" && !listed[t.fileId] {
+        return nil, BitcaskError(fmt.Sprintf("%s: %s", t.fileId, TailPositionMerged))
+    }
+    for name := range t.passed {
+        if !listed[name] {
+            delete(t.passed, name)
+        }
+    }
+
+    for _, name := range names {
+        offset, isPassed := t.passed[name]
+        if name == t.fileId {
+            offset = t.offset
+        }
+
+        fileData, err := readFile(t.fs, path.Join(t.dirPath, name))
+        if err != nil {
+            return events, err
+        }
+        if offset > len(fileData) {
+            return events, BitcaskError(fmt.Sprintf("%s: %s", name, TruncatedRecord))
+        }
+
+        currentPos := offset
+        for currentPos < len(fileData) {
+            line, n, err := splitFileLine(fileData[currentPos:])
+            if err != nil {
+                break
+            }
+            dataRec, err := extractFileLine(line)
+            if err != nil {
+                break
+            }
+            currentPos += n
+
+            if dataRec.flags & mergedFlag != 0 {
+                continue
+            }
+            dataRec, err = openRecord(t.keyring, dataRec)
+            if err != nil {
+                return events, BitcaskError(fmt.Sprintf("%s: %s", name, err))
+            }
+            if !strings.HasPrefix(dataRec.key, t.prefix) {
+                continue
+            }
+
+            event := Event{Type: PutEvent, Key: dataRec.key, Tstamp: dataRec.tstamp, FileId: name, Offset: currentPos}
+            if dataRec.flags & tombstoneFlag != 0 {
+                event.Type = DeleteEvent
+            } else if event.Value, err = decodeValue(dataRec); err != nil {
+                return events, BitcaskError(fmt.Sprintf("%s: %s", dataRec.key, err))
+            }
+            events = append(events, event)
+        }
+
+        // The tail moves on to the next file and remembers where it stopped in this one,
+        // in case records were still being written to it.
+        if isPassed {
+            t.passed[name] = currentPos
+            continue
+        }
+        if t.fileId != "" && name != t.fileId {
+            t.passed[t.fileId] = t.offset
+        }
+        t.fileId = name
+        t.offset = currentPos
+    }
+
+    return events, nil
+}
+
+// Position returns the data file and offset the next call to Next starts from.
+// Records written later to the older files the tail moved past are only read by this tail.
+func (t *Tail) Position() (string, int) {
+    return t.fileId, t.offset
+}
@@ -0,0 +1,148 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestWatch(t *testing.T) {
+    t.Run("put and delete events for prefix", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        events, cancel := b.Watch("user:", WatchValues)
+
+        b.Put("user:1", "value1")
+        b.Put("order:1", "value2")
+        b.Delete("user:1")
+        cancel()
+
+        var got []Event
+        for event := range events {
+            got = append(got, Event{Type: event.Type, Key: event.Key, Value: event.Value})
+        }
+        want := []Event{
+            {Type: PutEvent, Key: "user:1", Value: "value1"},
+            {Type: DeleteEvent, Key: "user:1"},
+        }
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("got:\n%+v\nwant:\n%+v", got, want)
+        }
+
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("slow watcher is dropped", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        events, cancel := b.Watch("")
+        defer cancel()
+
+        for i := 0; i < watchBufferSize + 1; i++ {
+            b.Put(fmt.Sprint(i), "value")
+        }
+
+        count := 0
+        for range events {
+            count++
+        }
+        if count != watchBufferSize {
+            t.Errorf("got %d events, want %d", count, watchBufferSize)
+        }
+
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+}
+
+func TestTail(t *testing.T) {
+    t.Run("tail resumes after the last event", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        events, cancel := b.Watch("")
+        b.Put("key1", "value1")
+        last := <-events
+        cancel()
+
+        tail := OpenTail(testBitcaskPath, "", last.FileId, last.Offset)
+        b.Put("key2", "value2")
+        b.Delete("key1")
+        b.Sync()
+
+        got, err := tail.Next()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(got) != 2 || got[0].Key != "key2" || got[0].Value != "value2" || got[1].Type != DeleteEvent {
+            t.Errorf("got unexpected events: %+v", got)
+        }
+
+        got, _ = tail.Next()
+        if len(got) != 0 {
+            t.Errorf("expected no new events, got: %+v", got)
+        }
+
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("tail skips merged records and follows rotations", func(t *testing.T) {
+        b, _ := Open(testBitcaskPath, ReadWrite)
+        for i := 0; i < 500; i++ {
+            b.Put(fmt.Sprintf("key%d", i + 1), "value")
+        }
+        b.Sync()
+
+        tail := OpenTail(testBitcaskPath, "", "", 0)
+        got, _ := tail.Next()
+        if len(got) != 500 {
+            t.Fatalf("got %d events, want 500", len(got))
+        }
+
+        b.Put("key1", "changed")
+        b.Merge()
+        b.Put("key2", "changed")
+        b.Sync()
+
+        got, err := tail.Next()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(got) != 2 || got[0].Key != "key1" || got[1].Key != "key2" {
+            t.Errorf("got unexpected events: %+v", got)
+        }
+
+        b.Close()
+        os.RemoveAll(testBitcaskPath)
+    })
+
+    t.Run("tail reads records written to a file it moved past", func(t *testing.T) {
+        fsys := NewMemFileSystem()
+        b, _ := OpenFS(fsys, testBitcaskPath, nil, ReadWrite)
+        b.Put("key1", "value1")
+        firstFile := b.activeFile.fileName
+        fillActiveFile(b)
+        b.Sync()
+
+        tail := OpenTail(testBitcaskPath, "key", "", 0)
+        tail.SetFileSystem(fsys)
+        got, _ := tail.Next()
+        if len(got) != 1 || got[0].Key != "key1" {
+            t.Fatalf("got unexpected events: %+v", got)
+        }
+
+        // A record of the older file that was not yet written when the tail moved on.
+        file, _ := fsys.OpenFile(path.Join(testBitcaskPath, firstFile), os.O_WRONLY | os.O_APPEND)
+        file.Write(append(compressFileLine("key2", "late", 0, 1, 0), '\n'))
+        file.Close()
+
+        got, err := tail.Next()
+        if err != nil || len(got) != 1 || got[0].Key != "key2" || got[0].FileId != firstFile {
+            t.Errorf("got events %+v, %v, want key2 in %s", got, err, firstFile)
+        }
+        if got, _ = tail.Next(); len(got) != 0 {
+            t.Errorf("expected no new events, got: %+v", got)
+        }
+        b.Close()
+    })
+}